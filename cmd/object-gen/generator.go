@@ -0,0 +1,789 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const objectPkgPath = "github.com/xaionaro-go/object"
+
+// generate returns the source code of a file with deep copy methods
+// for types `typeNames` of the package in directory `dir`.
+//
+// `outputFile` is excluded from the analysis (it is going to be overwritten).
+func generate(
+	dir string,
+	typeNames []string,
+	outputFile string,
+) ([]byte, error) {
+	pkg, err := loadPackage(dir, outputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newGenerator(pkg)
+	for _, name := range typeNames {
+		name = strings.TrimSpace(name)
+		obj := pkg.Scope().Lookup(name)
+		if obj == nil {
+			return nil, fmt.Errorf("type '%s' is not found in package '%s'", name, pkg.Name())
+		}
+		typeName, ok := obj.(*types.TypeName)
+		if !ok || typeName.IsAlias() {
+			return nil, fmt.Errorf("'%s' is not a defined type", name)
+		}
+		named := typeName.Type().(*types.Named)
+		if named.TypeParams().Len() > 0 {
+			return nil, fmt.Errorf("generic types are not supported (type '%s')", name)
+		}
+		if types.IsInterface(named) {
+			return nil, fmt.Errorf("interfaces cannot have methods (type '%s')", name)
+		}
+		g.requested = append(g.requested, named)
+	}
+	return g.generate()
+}
+
+func loadPackage(
+	dir string,
+	excludeFile string,
+) (*types.Package, error) {
+	buildPkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the package in '%s': %w", dir, err)
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range buildPkg.GoFiles {
+		if name == excludeFile {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': %w", name, err)
+		}
+		files = append(files, file)
+	}
+
+	var typeErrors []error
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			// The package may refer to the methods we are about to generate,
+			// so type errors are tolerated unless nothing was resolved.
+			typeErrors = append(typeErrors, err)
+		},
+	}
+	pkg, _ := conf.Check(buildPkg.ImportPath, fset, files, nil)
+	if pkg == nil || len(pkg.Scope().Names()) == 0 && len(typeErrors) > 0 {
+		return nil, fmt.Errorf("unable to type-check package in '%s': %v", dir, typeErrors)
+	}
+	return pkg, nil
+}
+
+type copyFunc struct {
+	Name string
+	Type types.Type
+	Body string
+}
+
+type generator struct {
+	pkg             *types.Package
+	requested       []*types.Named
+	imports         map[string]string // import path -> package name in the generated file
+	importPaths     map[string]string // package name in the generated file -> import path
+	funcs           []*copyFunc
+	funcByKey       map[string]*copyFunc
+	funcNames       map[string]struct{}
+	usesFallback    bool
+	usesKeyFallback bool
+	usesSeen        bool
+	errs            []error
+}
+
+func newGenerator(pkg *types.Package) *generator {
+	return &generator{
+		pkg:         pkg,
+		imports:     map[string]string{},
+		importPaths: map[string]string{},
+		funcByKey:   map[string]*copyFunc{},
+		funcNames:   map[string]struct{}{},
+	}
+}
+
+func (g *generator) generate() ([]byte, error) {
+	for _, named := range g.requested {
+		g.funcFor(named)
+	}
+	// The list grows while generating the bodies. The bodies of
+	// interfaces and pointers refer to all the types with copy functions
+	// (to preserve the identity of pointers), so they are (re)generated
+	// when no more functions are added.
+	for done := 0; done < len(g.funcs); {
+		for ; done < len(g.funcs); done++ {
+			if !isLateBody(g.funcs[done].Type) {
+				g.genBody(g.funcs[done])
+			}
+		}
+		for _, fn := range g.funcs {
+			if isLateBody(fn.Type) {
+				g.genBody(fn)
+			}
+		}
+	}
+	if len(g.errs) > 0 {
+		return nil, errors.Join(g.errs...)
+	}
+
+	var body bytes.Buffer
+	for _, named := range g.requested {
+		typ := g.typeExpr(named)
+		fn := g.funcFor(named)
+		fmt.Fprintf(&body, "// DeepCopy returns a deep copy of the value, see object.DeepCopy.\n")
+		fmt.Fprintf(&body, "//\n// It panics with object.ErrMapKeyCollision if two different keys of a map\n")
+		fmt.Fprintf(&body, "// become equal (the default object.MapKeyCollisionError policy).\n")
+		fmt.Fprintf(&body, "func (v %s) DeepCopy() %s {\nreturn (&objectGenCopier{}).%s(v)\n}\n\n", typ, typ, fn.Name)
+		fmt.Fprintf(&body, "// DeepCopyWithoutSecrets returns a deep copy of the value with all\n")
+		fmt.Fprintf(&body, "// fields tagged as `secret:\"\"` reset, see object.DeepCopyWithoutSecrets.\n")
+		fmt.Fprintf(&body, "//\n// It panics with object.ErrMapKeyCollision if two different keys of a map\n")
+		fmt.Fprintf(&body, "// become equal (the default object.MapKeyCollisionError policy).\n")
+		fmt.Fprintf(&body, "func (v %s) DeepCopyWithoutSecrets() %s {\nreturn (&objectGenCopier{withoutSecrets: true}).%s(v)\n}\n\n", typ, typ, fn.Name)
+		fmt.Fprintf(&body, "// ObjectGenerated marks the methods above as generated, see object.GeneratedCopier.\n")
+		fmt.Fprintf(&body, "func (%s) ObjectGenerated() {}\n\n", typ)
+	}
+
+	fmt.Fprintf(&body, "type objectGenCopier struct {\nwithoutSecrets bool\n")
+	for _, fn := range g.funcs {
+		if isPointer(fn.Type) {
+			typ := g.typeExpr(fn.Type)
+			fmt.Fprintf(&body, "%s map[%s]%s\n", seenField(fn), typ, typ)
+		}
+	}
+	fmt.Fprintf(&body, "}\n\n")
+
+	for _, fn := range g.funcs {
+		fmt.Fprintf(&body, "// %s deep copies a value of type %s.\n", fn.Name, g.typeExpr(fn.Type))
+		fmt.Fprintf(&body, "func (c *objectGenCopier) %s(src %s) %s {\n%s}\n\n", fn.Name, g.typeExpr(fn.Type), g.typeExpr(fn.Type), fn.Body)
+	}
+
+	if g.usesFallback {
+		objectPkgName := g.importName(objectPkgPath, "object")
+		fmt.Fprintf(&body, "// objectGenFallback deep copies values which cannot be handled by the generated code.\n")
+		fmt.Fprintf(&body, "// They are copied separately, so the pointers they share with the rest\n")
+		fmt.Fprintf(&body, "// of the value are not shared by the copies.\n")
+		fmt.Fprintf(&body, "func objectGenFallback[T any](c *objectGenCopier, v T) T {\n")
+		fmt.Fprintf(&body, "if c.withoutSecrets {\nreturn %s.DeepCopyWithoutSecrets(v)\n}\n", objectPkgName)
+		fmt.Fprintf(&body, "return %s.DeepCopy(v)\n}\n", objectPkgName)
+	}
+	if g.usesSeen {
+		fmt.Fprintf(&body, "\n// objectGenSeen remembers that `src` is copied to `dst` (unless `src` is already copied).\n")
+		fmt.Fprintf(&body, "func objectGenSeen[T comparable](seen *map[T]T, src, dst T) {\n")
+		fmt.Fprintf(&body, "if *seen == nil {\n*seen = map[T]T{}\n}\n")
+		fmt.Fprintf(&body, "if _, ok := (*seen)[src]; !ok {\n(*seen)[src] = dst\n}\n}\n")
+	}
+	if g.usesKeyFallback {
+		objectPkgName := g.importName(objectPkgPath, "object")
+		fmt.Fprintf(&body, "\n// objectGenFallbackKey deep copies keys of maps which cannot be handled by the generated code.\n")
+		fmt.Fprintf(&body, "func objectGenFallbackKey[T any](c *objectGenCopier, k T) T {\n")
+		fmt.Fprintf(&body, "if c.withoutSecrets {\nreturn %s.DeepCopyWithoutSecrets(k, %s.OptionWithUnexported(true))\n}\n", objectPkgName, objectPkgName)
+		fmt.Fprintf(&body, "return %s.DeepCopy(k, %s.OptionWithUnexported(true))\n}\n", objectPkgName, objectPkgName)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by object-gen; DO NOT EDIT.\n\npackage %s\n\n", g.pkg.Name())
+	if len(g.imports) > 0 {
+		paths := make([]string, 0, len(g.imports))
+		for path := range g.imports {
+			paths = append(paths, path)
+		}
+		// the standard library first, the rest after an empty line
+		sort.Slice(paths, func(i, j int) bool {
+			iStd, jStd := isStdPackage(paths[i]), isStdPackage(paths[j])
+			if iStd != jStd {
+				return iStd
+			}
+			return paths[i] < paths[j]
+		})
+		fmt.Fprintf(&out, "import (\n")
+		for idx, path := range paths {
+			if idx > 0 && isStdPackage(paths[idx-1]) && !isStdPackage(path) {
+				fmt.Fprintf(&out, "\n")
+			}
+			name := g.imports[path]
+			if name == filepath.Base(path) {
+				fmt.Fprintf(&out, "%q\n", path)
+			} else {
+				fmt.Fprintf(&out, "%s %q\n", name, path)
+			}
+		}
+		fmt.Fprintf(&out, ")\n\n")
+	}
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("internal error: unable to format the generated code: %w\n%s", err, out.Bytes())
+	}
+	return src, nil
+}
+
+func isStdPackage(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+func (g *generator) importName(path string, name string) string {
+	if name, ok := g.imports[path]; ok {
+		return name
+	}
+	alias := name
+	for i := 2; ; i++ {
+		_, taken := g.importPaths[alias]
+		if !taken && g.pkg.Scope().Lookup(alias) == nil {
+			break
+		}
+		alias = fmt.Sprintf("%s%d", name, i)
+	}
+	g.imports[path] = alias
+	g.importPaths[alias] = path
+	return alias
+}
+
+func (g *generator) qualifier(pkg *types.Package) string {
+	if pkg == g.pkg {
+		return ""
+	}
+	return g.importName(pkg.Path(), pkg.Name())
+}
+
+func (g *generator) typeExpr(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+func typeKey(t types.Type) string {
+	return types.TypeString(t, func(pkg *types.Package) string { return pkg.Path() })
+}
+
+func isInterface(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Interface)
+	return ok
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
+
+// isLateBody returns true if the body of the copy function of type `t`
+// depends on the set of all the copy functions.
+func isLateBody(t types.Type) bool {
+	return isInterface(t) || isPointer(t)
+}
+
+// seenField returns the name of the field of objectGenCopier with
+// the already copied values of pointer type copied by `fn`.
+func seenField(fn *copyFunc) string {
+	return "seen" + strings.TrimPrefix(fn.Name, "copy")
+}
+
+func isSecret(tag string) bool {
+	_, ok := reflect.StructTag(tag).Lookup("secret")
+	return ok
+}
+
+// fieldPolicy is the copy behavior of a struct field defined by
+// tag `object:"..."` (mirrors fieldPolicy of package object).
+type fieldPolicy struct {
+	Skip           bool
+	Shallow        bool
+	CopyUnexported bool
+}
+
+func parseFieldPolicy(tag string) (fieldPolicy, error) {
+	value, ok := reflect.StructTag(tag).Lookup("object")
+	if !ok {
+		return fieldPolicy{}, nil
+	}
+	if value == "-" {
+		return fieldPolicy{Skip: true}, nil
+	}
+	var policy fieldPolicy
+	for _, opt := range strings.Split(value, ",") {
+		switch opt {
+		case "":
+		case "shallow":
+			policy.Shallow = true
+		case "copy=unexported":
+			policy.CopyUnexported = true
+		default:
+			return fieldPolicy{}, fmt.Errorf("unknown option '%s' in tag `object:\"%s\"`", opt, value)
+		}
+	}
+	return policy, nil
+}
+
+// isBulkCopyable returns true if a value of type `t` could be deep copied
+// by a simple assignment (mirrors copyPlanBuilder.isBulkCopyable of package object).
+func (g *generator) isBulkCopyable(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic, *types.Chan, *types.Signature:
+		return true
+	case *types.Array:
+		return u.Len() == 0 || g.isBulkCopyable(u.Elem())
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			policy, err := parseFieldPolicy(u.Tag(i))
+			if err != nil || policy.Skip || isSecret(u.Tag(i)) {
+				return false
+			}
+			if !f.Exported() && !policy.CopyUnexported {
+				return false
+			}
+			if policy.Shallow {
+				continue
+			}
+			if !g.isBulkCopyable(f.Type()) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isKeyBulkCopyable is the same as isBulkCopyable, but for keys of maps:
+// their unexported fields are copied as well (mirrors package object).
+func (g *generator) isKeyBulkCopyable(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Array:
+		return u.Len() == 0 || g.isKeyBulkCopyable(u.Elem())
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			policy, err := parseFieldPolicy(u.Tag(i))
+			if err != nil || policy.Skip || isSecret(u.Tag(i)) {
+				return false
+			}
+			if !policy.Shallow && !g.isKeyBulkCopyable(u.Field(i).Type()) {
+				return false
+			}
+		}
+		return true
+	default:
+		return g.isBulkCopyable(t)
+	}
+}
+
+// hasUnexportedFields returns true if a value of type `t` may contain
+// unexported fields of structs (directly or indirectly).
+func hasUnexportedFields(t types.Type, visited map[types.Type]struct{}) bool {
+	if named, ok := types.Unalias(t).(*types.Named); ok {
+		if _, ok := visited[named]; ok {
+			return false
+		}
+		visited[named] = struct{}{}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Pointer:
+		return hasUnexportedFields(u.Elem(), visited)
+	case *types.Slice:
+		return hasUnexportedFields(u.Elem(), visited)
+	case *types.Array:
+		return hasUnexportedFields(u.Elem(), visited)
+	case *types.Map:
+		return hasUnexportedFields(u.Key(), visited) || hasUnexportedFields(u.Elem(), visited)
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if !f.Exported() || hasUnexportedFields(f.Type(), visited) {
+				return true
+			}
+		}
+		return false
+	case *types.Interface:
+		// could contain anything
+		return true
+	default:
+		return false
+	}
+}
+
+// isAccessible returns true if type `t` could be referred to
+// from the generated code.
+func (g *generator) isAccessible(t types.Type) bool {
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		return true
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil {
+			// builtin, e.g. "error"
+			return true
+		}
+		if obj.Parent() != obj.Pkg().Scope() {
+			// declared inside a function
+			return false
+		}
+		if obj.Pkg() != g.pkg && !obj.Exported() {
+			return false
+		}
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if !g.isAccessible(t.TypeArgs().At(i)) {
+				return false
+			}
+		}
+		return true
+	case *types.Pointer:
+		return g.isAccessible(t.Elem())
+	case *types.Slice:
+		return g.isAccessible(t.Elem())
+	case *types.Array:
+		return g.isAccessible(t.Elem())
+	case *types.Chan:
+		return g.isAccessible(t.Elem())
+	case *types.Map:
+		return g.isAccessible(t.Key()) && g.isAccessible(t.Elem())
+	case *types.Signature:
+		for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+			for i := 0; i < tuple.Len(); i++ {
+				if !g.isAccessible(tuple.At(i).Type()) {
+					return false
+				}
+			}
+		}
+		return true
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			f := t.Field(i)
+			if !f.Exported() && f.Pkg() != g.pkg {
+				return false
+			}
+			if !g.isAccessible(f.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Interface:
+		for i := 0; i < t.NumMethods(); i++ {
+			m := t.Method(i)
+			if !m.Exported() && m.Pkg() != g.pkg {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// copyExpr returns an expression that deep copies `src` of type `t`.
+func (g *generator) copyExpr(t types.Type, src string) string {
+	t = types.Unalias(t)
+	if g.isBulkCopyable(t) {
+		return src
+	}
+	if !g.isAccessible(t) {
+		g.usesFallback = true
+		return "objectGenFallback(c, " + src + ")"
+	}
+	return "c." + g.funcFor(t).Name + "(" + src + ")"
+}
+
+// copyKeyExpr returns an expression that deep copies key `src`
+// of type `t` of a map.
+func (g *generator) copyKeyExpr(t types.Type, src string) string {
+	t = types.Unalias(t)
+	if g.isKeyBulkCopyable(t) {
+		return src
+	}
+	if hasUnexportedFields(t, map[types.Type]struct{}{}) || !g.isAccessible(t) {
+		g.usesKeyFallback = true
+		return "objectGenFallbackKey(c, " + src + ")"
+	}
+	return g.copyExpr(t, src)
+}
+
+func (g *generator) funcFor(t types.Type) *copyFunc {
+	key := typeKey(t)
+	if fn, ok := g.funcByKey[key]; ok {
+		return fn
+	}
+	for _, fn := range g.funcs {
+		// the same type may be spelled differently,
+		// for example map[string]any and map[string]interface{}
+		if types.Identical(fn.Type, t) {
+			g.funcByKey[key] = fn
+			return fn
+		}
+	}
+
+	name := "copy" + g.funcNameSuffix(t)
+	for i := 2; ; i++ {
+		if _, taken := g.funcNames[name]; !taken {
+			break
+		}
+		name = fmt.Sprintf("copy%s%d", g.funcNameSuffix(t), i)
+	}
+	fn := &copyFunc{
+		Name: name,
+		Type: t,
+	}
+	g.funcNames[name] = struct{}{}
+	g.funcByKey[key] = fn
+	g.funcs = append(g.funcs, fn)
+	return fn
+}
+
+func exportedName(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func (g *generator) funcNameSuffix(t types.Type) string {
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		return exportedName(t.Name())
+	case *types.Named:
+		name := exportedName(t.Obj().Name())
+		if pkg := t.Obj().Pkg(); pkg != nil && pkg != g.pkg {
+			name = exportedName(pkg.Name()) + name
+		}
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			name += g.funcNameSuffix(t.TypeArgs().At(i))
+		}
+		return name
+	case *types.Pointer:
+		return "Ptr" + g.funcNameSuffix(t.Elem())
+	case *types.Slice:
+		return "Slice" + g.funcNameSuffix(t.Elem())
+	case *types.Array:
+		return fmt.Sprintf("Array%d", t.Len()) + g.funcNameSuffix(t.Elem())
+	case *types.Map:
+		return "Map" + g.funcNameSuffix(t.Key()) + g.funcNameSuffix(t.Elem())
+	case *types.Chan:
+		return "Chan" + g.funcNameSuffix(t.Elem())
+	case *types.Signature:
+		return "Func"
+	case *types.Struct:
+		return "Struct"
+	case *types.Interface:
+		return "Interface"
+	default:
+		return "Value"
+	}
+}
+
+// genSeenParts generates the code which remembers the copies of
+// the parts (fields and array elements, recursively) of value `src`
+// of type `t` (which is being copied to `dst`), for all the pointer
+// types with copy functions. If `all` is true, then the fields are
+// copied as is (see tag `object:"shallow"`), including skipped ones.
+func (g *generator) genSeenParts(
+	b *strings.Builder,
+	t types.Type,
+	src, dst string,
+	depth int,
+	all bool,
+) {
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if f.Name() == "_" || !f.Exported() && f.Pkg() != g.pkg {
+				// not accessible from the generated code
+				continue
+			}
+			policy, _ := parseFieldPolicy(u.Tag(i))
+			if !all && (policy.Skip || !f.Exported() && !policy.CopyUnexported) {
+				continue
+			}
+			fSrc, fDst := src+"."+f.Name(), dst+"."+f.Name()
+			g.genSeenPart(b, f.Type(), fSrc, fDst)
+			g.genSeenParts(b, f.Type(), fSrc, fDst, depth, all || policy.Shallow)
+		}
+	case *types.Array:
+		idx := fmt.Sprintf("i%d", depth)
+		var elem strings.Builder
+		eSrc, eDst := src+"["+idx+"]", dst+"["+idx+"]"
+		g.genSeenPart(&elem, u.Elem(), eSrc, eDst)
+		g.genSeenParts(&elem, u.Elem(), eSrc, eDst, depth+1, all)
+		if elem.Len() > 0 {
+			fmt.Fprintf(b, "for %s := range %s {\n%s}\n", idx, src, elem.String())
+		}
+	}
+}
+
+// genSeenPart generates the code which remembers that `&src` is copied
+// to `&dst` for all the pointer types to `t` with copy functions.
+func (g *generator) genSeenPart(b *strings.Builder, t types.Type, src, dst string) {
+	for _, fn := range g.funcs {
+		ptr, ok := fn.Type.Underlying().(*types.Pointer)
+		if !ok || !types.Identical(ptr.Elem(), t) {
+			continue
+		}
+		g.usesSeen = true
+		if _, isNamed := fn.Type.(*types.Named); isNamed {
+			typ := g.typeExpr(fn.Type)
+			fmt.Fprintf(b, "objectGenSeen(&c.%s, %s(&%s), %s(&%s))\n", seenField(fn), typ, src, typ, dst)
+		} else {
+			fmt.Fprintf(b, "objectGenSeen(&c.%s, &%s, &%s)\n", seenField(fn), src, dst)
+		}
+	}
+}
+
+func (g *generator) genBody(fn *copyFunc) {
+	t := fn.Type
+	typ := g.typeExpr(t)
+	var b strings.Builder
+	defer func() {
+		fn.Body = b.String()
+	}()
+
+	if g.isBulkCopyable(t) {
+		fmt.Fprintf(&b, "return src\n")
+		return
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Pointer:
+		field := seenField(fn)
+		fmt.Fprintf(&b, "if src == nil {\nreturn nil\n}\n")
+		fmt.Fprintf(&b, "if dst, ok := c.%s[src]; ok {\nreturn dst\n}\n", field)
+		fmt.Fprintf(&b, "if c.%s == nil {\nc.%s = map[%s]%s{}\n}\n", field, field, typ, typ)
+		if _, isNamed := t.(*types.Named); isNamed {
+			fmt.Fprintf(&b, "dst := %s(new(%s))\n", typ, g.typeExpr(u.Elem()))
+		} else {
+			fmt.Fprintf(&b, "dst := new(%s)\n", g.typeExpr(u.Elem()))
+		}
+		fmt.Fprintf(&b, "c.%s[src] = dst\n", field)
+		// the same as object.DeepCopy: the pointers to the parts of
+		// the value are resolved into the copy
+		if _, isStruct := u.Elem().Underlying().(*types.Struct); isStruct {
+			g.genSeenParts(&b, u.Elem(), "src", "dst", 0, false)
+		} else {
+			g.genSeenParts(&b, u.Elem(), "(*src)", "(*dst)", 0, false)
+		}
+		fmt.Fprintf(&b, "*dst = %s\n", g.copyExpr(u.Elem(), "*src"))
+		fmt.Fprintf(&b, "return dst\n")
+	case *types.Slice:
+		fmt.Fprintf(&b, "if src == nil {\nreturn nil\n}\n")
+		fmt.Fprintf(&b, "dst := make(%s, len(src))\n", typ)
+		if g.isBulkCopyable(u.Elem()) {
+			fmt.Fprintf(&b, "copy(dst, src)\n")
+		} else {
+			fmt.Fprintf(&b, "for i := range src {\ndst[i] = %s\n}\n", g.copyExpr(u.Elem(), "src[i]"))
+		}
+		fmt.Fprintf(&b, "return dst\n")
+	case *types.Array:
+		fmt.Fprintf(&b, "var dst %s\n", typ)
+		fmt.Fprintf(&b, "for i := range src {\ndst[i] = %s\n}\n", g.copyExpr(u.Elem(), "src[i]"))
+		fmt.Fprintf(&b, "return dst\n")
+	case *types.Map:
+		fmt.Fprintf(&b, "if src == nil {\nreturn nil\n}\n")
+		fmt.Fprintf(&b, "dst := make(%s, len(src))\n", typ)
+		if g.isKeyBulkCopyable(u.Key()) {
+			// the keys are reused as is, so they cannot collide
+			fmt.Fprintf(&b, "for k, v := range src {\ndst[k] = %s\n}\n", g.copyExpr(u.Elem(), "v"))
+		} else {
+			objectPkgName := g.importName(objectPkgPath, "object")
+			fmt.Fprintf(&b, "for k, v := range src {\n")
+			fmt.Fprintf(&b, "dstK := %s\n", g.copyKeyExpr(u.Key(), "k"))
+			fmt.Fprintf(&b, "if _, ok := dst[dstK]; ok {\npanic(%s.ErrMapKeyCollision)\n}\n", objectPkgName)
+			fmt.Fprintf(&b, "dst[dstK] = %s\n}\n", g.copyExpr(u.Elem(), "v"))
+		}
+		fmt.Fprintf(&b, "return dst\n")
+	case *types.Struct:
+		policies := make([]fieldPolicy, u.NumFields())
+		for i := range policies {
+			f := u.Field(i)
+			policy, err := parseFieldPolicy(u.Tag(i))
+			if err != nil {
+				g.errs = append(g.errs, fmt.Errorf("field '%s' of type '%s': %w", f.Name(), typ, err))
+			}
+			if !f.Exported() && policy.CopyUnexported && f.Pkg() != g.pkg {
+				// the field is not accessible from the generated code
+				g.usesFallback = true
+				fmt.Fprintf(&b, "return objectGenFallback(c, src)\n")
+				return
+			}
+			policies[i] = policy
+		}
+		fmt.Fprintf(&b, "var dst %s\n", typ)
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			policy := policies[i]
+			if policy.Skip {
+				continue
+			}
+			if !f.Exported() && !policy.CopyUnexported {
+				// the same as object.DeepCopy: unexported fields are not copied
+				continue
+			}
+			expr := "src." + f.Name()
+			if !policy.Shallow {
+				expr = g.copyExpr(f.Type(), expr)
+			}
+			assign := fmt.Sprintf("dst.%s = %s\n", f.Name(), expr)
+			if isSecret(u.Tag(i)) {
+				fmt.Fprintf(&b, "if !c.withoutSecrets {\n%s}\n", assign)
+			} else {
+				b.WriteString(assign)
+			}
+		}
+		fmt.Fprintf(&b, "return dst\n")
+	case *types.Interface:
+		fmt.Fprintf(&b, "if src == nil {\nreturn nil\n}\n")
+		// The requested types, and all the types copied by the generated
+		// code: the values inside the interface may share pointers with
+		// the rest of the object, so they need to be copied by the same
+		// copier (instead of objectGenFallback).
+		var candidates []types.Type
+		for _, named := range g.requested {
+			candidates = append(candidates, named, types.NewPointer(named))
+		}
+		for _, fn := range g.funcs {
+			candidates = append(candidates, fn.Type)
+		}
+		var cases strings.Builder
+		var added []types.Type
+		for _, candidate := range candidates {
+			if isInterface(candidate) || !types.AssignableTo(candidate, t) {
+				continue
+			}
+			isAdded := slices.ContainsFunc(added, func(t types.Type) bool {
+				return types.Identical(t, candidate)
+			})
+			if isAdded {
+				continue
+			}
+			added = append(added, candidate)
+			fmt.Fprintf(&cases, "case %s:\nreturn %s\n", g.typeExpr(candidate), g.copyExpr(candidate, "src"))
+		}
+		if cases.Len() > 0 {
+			fmt.Fprintf(&b, "switch src := src.(type) {\n%s}\n", cases.String())
+		}
+		g.usesFallback = true
+		fmt.Fprintf(&b, "return objectGenFallback(c, src)\n")
+	default:
+		panic(fmt.Errorf("internal error: unexpected type %s", typ))
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateIsUpToDate(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	expected, err := os.ReadFile(filepath.Join(dir, defaultOutputFile))
+	require.NoError(t, err)
+
+	generated, err := generate(dir, []string{"Config", "Node"}, defaultOutputFile)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(generated), "please run 'go generate ./...'")
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	_, err := generate(dir, []string{"NotExistingType"}, defaultOutputFile)
+	require.Error(t, err)
+}
@@ -0,0 +1,361 @@
+// Code generated by object-gen; DO NOT EDIT.
+
+package example
+
+import (
+	"time"
+
+	"github.com/xaionaro-go/object"
+)
+
+// DeepCopy returns a deep copy of the value, see object.DeepCopy.
+//
+// It panics with object.ErrMapKeyCollision if two different keys of a map
+// become equal (the default object.MapKeyCollisionError policy).
+func (v Config) DeepCopy() Config {
+	return (&objectGenCopier{}).copyConfig(v)
+}
+
+// DeepCopyWithoutSecrets returns a deep copy of the value with all
+// fields tagged as `secret:""` reset, see object.DeepCopyWithoutSecrets.
+//
+// It panics with object.ErrMapKeyCollision if two different keys of a map
+// become equal (the default object.MapKeyCollisionError policy).
+func (v Config) DeepCopyWithoutSecrets() Config {
+	return (&objectGenCopier{withoutSecrets: true}).copyConfig(v)
+}
+
+// ObjectGenerated marks the methods above as generated, see object.GeneratedCopier.
+func (Config) ObjectGenerated() {}
+
+// DeepCopy returns a deep copy of the value, see object.DeepCopy.
+//
+// It panics with object.ErrMapKeyCollision if two different keys of a map
+// become equal (the default object.MapKeyCollisionError policy).
+func (v Node) DeepCopy() Node {
+	return (&objectGenCopier{}).copyNode(v)
+}
+
+// DeepCopyWithoutSecrets returns a deep copy of the value with all
+// fields tagged as `secret:""` reset, see object.DeepCopyWithoutSecrets.
+//
+// It panics with object.ErrMapKeyCollision if two different keys of a map
+// become equal (the default object.MapKeyCollisionError policy).
+func (v Node) DeepCopyWithoutSecrets() Node {
+	return (&objectGenCopier{withoutSecrets: true}).copyNode(v)
+}
+
+// ObjectGenerated marks the methods above as generated, see object.GeneratedCopier.
+func (Node) ObjectGenerated() {}
+
+type objectGenCopier struct {
+	withoutSecrets     bool
+	seenPtrCredentials map[*Credentials]*Credentials
+	seenPtrString      map[*string]*string
+	seenPtrNode        map[*Node]*Node
+	seenPtrConfig      map[*Config]*Config
+}
+
+// copyConfig deep copies a value of type Config.
+func (c *objectGenCopier) copyConfig(src Config) Config {
+	var dst Config
+	dst.Name = src.Name
+	dst.Credentials = c.copyPtrCredentials(src.Credentials)
+	dst.Login = c.copyPtrString(src.Login)
+	dst.Backups = c.copySliceCredentials(src.Backups)
+	dst.Tags = c.copyMapStringSliceString(src.Tags)
+	dst.Owners = c.copyMapCredentialsString(src.Owners)
+	dst.Schedule = c.copyMapTimeTimeString(src.Schedule)
+	dst.Nodes = c.copyArray2PtrNode(src.Nodes)
+	dst.Extra = c.copyInterface(src.Extra)
+	if !c.withoutSecrets {
+		dst.Token = c.copySliceByte(src.Token)
+	}
+	dst.UpdatedAt = c.copyTimeTime(src.UpdatedAt)
+	dst.Cache = src.Cache
+	dst.revision = src.revision
+	return dst
+}
+
+// copyNode deep copies a value of type Node.
+func (c *objectGenCopier) copyNode(src Node) Node {
+	var dst Node
+	dst.ID = src.ID
+	dst.Parent = c.copyPtrNode(src.Parent)
+	dst.Children = c.copySlicePtrNode(src.Children)
+	dst.Data = c.copyMapStringInterface(src.Data)
+	dst.Labels = c.copyMapStringInterface(src.Labels)
+	if !c.withoutSecrets {
+		dst.Secret = src.Secret
+	}
+	return dst
+}
+
+// copyPtrCredentials deep copies a value of type *Credentials.
+func (c *objectGenCopier) copyPtrCredentials(src *Credentials) *Credentials {
+	if src == nil {
+		return nil
+	}
+	if dst, ok := c.seenPtrCredentials[src]; ok {
+		return dst
+	}
+	if c.seenPtrCredentials == nil {
+		c.seenPtrCredentials = map[*Credentials]*Credentials{}
+	}
+	dst := new(Credentials)
+	c.seenPtrCredentials[src] = dst
+	objectGenSeen(&c.seenPtrString, &src.Login, &dst.Login)
+	objectGenSeen(&c.seenPtrString, &src.Password, &dst.Password)
+	*dst = c.copyCredentials(*src)
+	return dst
+}
+
+// copyPtrString deep copies a value of type *string.
+func (c *objectGenCopier) copyPtrString(src *string) *string {
+	if src == nil {
+		return nil
+	}
+	if dst, ok := c.seenPtrString[src]; ok {
+		return dst
+	}
+	if c.seenPtrString == nil {
+		c.seenPtrString = map[*string]*string{}
+	}
+	dst := new(string)
+	c.seenPtrString[src] = dst
+	*dst = *src
+	return dst
+}
+
+// copySliceCredentials deep copies a value of type []Credentials.
+func (c *objectGenCopier) copySliceCredentials(src []Credentials) []Credentials {
+	if src == nil {
+		return nil
+	}
+	dst := make([]Credentials, len(src))
+	for i := range src {
+		dst[i] = c.copyCredentials(src[i])
+	}
+	return dst
+}
+
+// copyMapStringSliceString deep copies a value of type map[string][]string.
+func (c *objectGenCopier) copyMapStringSliceString(src map[string][]string) map[string][]string {
+	if src == nil {
+		return nil
+	}
+	dst := make(map[string][]string, len(src))
+	for k, v := range src {
+		dst[k] = c.copySliceString(v)
+	}
+	return dst
+}
+
+// copyMapCredentialsString deep copies a value of type map[Credentials]string.
+func (c *objectGenCopier) copyMapCredentialsString(src map[Credentials]string) map[Credentials]string {
+	if src == nil {
+		return nil
+	}
+	dst := make(map[Credentials]string, len(src))
+	for k, v := range src {
+		dstK := c.copyCredentials(k)
+		if _, ok := dst[dstK]; ok {
+			panic(object.ErrMapKeyCollision)
+		}
+		dst[dstK] = v
+	}
+	return dst
+}
+
+// copyMapTimeTimeString deep copies a value of type map[time.Time]string.
+func (c *objectGenCopier) copyMapTimeTimeString(src map[time.Time]string) map[time.Time]string {
+	if src == nil {
+		return nil
+	}
+	dst := make(map[time.Time]string, len(src))
+	for k, v := range src {
+		dstK := objectGenFallbackKey(c, k)
+		if _, ok := dst[dstK]; ok {
+			panic(object.ErrMapKeyCollision)
+		}
+		dst[dstK] = v
+	}
+	return dst
+}
+
+// copyArray2PtrNode deep copies a value of type [2]*Node.
+func (c *objectGenCopier) copyArray2PtrNode(src [2]*Node) [2]*Node {
+	var dst [2]*Node
+	for i := range src {
+		dst[i] = c.copyPtrNode(src[i])
+	}
+	return dst
+}
+
+// copyInterface deep copies a value of type interface{}.
+func (c *objectGenCopier) copyInterface(src interface{}) interface{} {
+	if src == nil {
+		return nil
+	}
+	switch src := src.(type) {
+	case Config:
+		return c.copyConfig(src)
+	case *Config:
+		return c.copyPtrConfig(src)
+	case Node:
+		return c.copyNode(src)
+	case *Node:
+		return c.copyPtrNode(src)
+	case *Credentials:
+		return c.copyPtrCredentials(src)
+	case *string:
+		return c.copyPtrString(src)
+	case []Credentials:
+		return c.copySliceCredentials(src)
+	case map[string][]string:
+		return c.copyMapStringSliceString(src)
+	case map[Credentials]string:
+		return c.copyMapCredentialsString(src)
+	case map[time.Time]string:
+		return c.copyMapTimeTimeString(src)
+	case [2]*Node:
+		return c.copyArray2PtrNode(src)
+	case []byte:
+		return c.copySliceByte(src)
+	case time.Time:
+		return c.copyTimeTime(src)
+	case []*Node:
+		return c.copySlicePtrNode(src)
+	case map[string]any:
+		return c.copyMapStringInterface(src)
+	case Credentials:
+		return c.copyCredentials(src)
+	case []string:
+		return c.copySliceString(src)
+	}
+	return objectGenFallback(c, src)
+}
+
+// copySliceByte deep copies a value of type []byte.
+func (c *objectGenCopier) copySliceByte(src []byte) []byte {
+	if src == nil {
+		return nil
+	}
+	dst := make([]byte, len(src))
+	copy(dst, src)
+	return dst
+}
+
+// copyTimeTime deep copies a value of type time.Time.
+func (c *objectGenCopier) copyTimeTime(src time.Time) time.Time {
+	var dst time.Time
+	return dst
+}
+
+// copyPtrNode deep copies a value of type *Node.
+func (c *objectGenCopier) copyPtrNode(src *Node) *Node {
+	if src == nil {
+		return nil
+	}
+	if dst, ok := c.seenPtrNode[src]; ok {
+		return dst
+	}
+	if c.seenPtrNode == nil {
+		c.seenPtrNode = map[*Node]*Node{}
+	}
+	dst := new(Node)
+	c.seenPtrNode[src] = dst
+	objectGenSeen(&c.seenPtrString, &src.Secret, &dst.Secret)
+	*dst = c.copyNode(*src)
+	return dst
+}
+
+// copySlicePtrNode deep copies a value of type []*Node.
+func (c *objectGenCopier) copySlicePtrNode(src []*Node) []*Node {
+	if src == nil {
+		return nil
+	}
+	dst := make([]*Node, len(src))
+	for i := range src {
+		dst[i] = c.copyPtrNode(src[i])
+	}
+	return dst
+}
+
+// copyMapStringInterface deep copies a value of type map[string]any.
+func (c *objectGenCopier) copyMapStringInterface(src map[string]any) map[string]any {
+	if src == nil {
+		return nil
+	}
+	dst := make(map[string]any, len(src))
+	for k, v := range src {
+		dst[k] = c.copyInterface(v)
+	}
+	return dst
+}
+
+// copyCredentials deep copies a value of type Credentials.
+func (c *objectGenCopier) copyCredentials(src Credentials) Credentials {
+	var dst Credentials
+	dst.Login = src.Login
+	if !c.withoutSecrets {
+		dst.Password = src.Password
+	}
+	return dst
+}
+
+// copySliceString deep copies a value of type []string.
+func (c *objectGenCopier) copySliceString(src []string) []string {
+	if src == nil {
+		return nil
+	}
+	dst := make([]string, len(src))
+	copy(dst, src)
+	return dst
+}
+
+// copyPtrConfig deep copies a value of type *Config.
+func (c *objectGenCopier) copyPtrConfig(src *Config) *Config {
+	if src == nil {
+		return nil
+	}
+	if dst, ok := c.seenPtrConfig[src]; ok {
+		return dst
+	}
+	if c.seenPtrConfig == nil {
+		c.seenPtrConfig = map[*Config]*Config{}
+	}
+	dst := new(Config)
+	c.seenPtrConfig[src] = dst
+	objectGenSeen(&c.seenPtrString, &src.Name, &dst.Name)
+	*dst = c.copyConfig(*src)
+	return dst
+}
+
+// objectGenFallback deep copies values which cannot be handled by the generated code.
+// They are copied separately, so the pointers they share with the rest
+// of the value are not shared by the copies.
+func objectGenFallback[T any](c *objectGenCopier, v T) T {
+	if c.withoutSecrets {
+		return object.DeepCopyWithoutSecrets(v)
+	}
+	return object.DeepCopy(v)
+}
+
+// objectGenSeen remembers that `src` is copied to `dst` (unless `src` is already copied).
+func objectGenSeen[T comparable](seen *map[T]T, src, dst T) {
+	if *seen == nil {
+		*seen = map[T]T{}
+	}
+	if _, ok := (*seen)[src]; !ok {
+		(*seen)[src] = dst
+	}
+}
+
+// objectGenFallbackKey deep copies keys of maps which cannot be handled by the generated code.
+func objectGenFallbackKey[T any](c *objectGenCopier, k T) T {
+	if c.withoutSecrets {
+		return object.DeepCopyWithoutSecrets(k, object.OptionWithUnexported(true))
+	}
+	return object.DeepCopy(k, object.OptionWithUnexported(true))
+}
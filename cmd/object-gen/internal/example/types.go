@@ -0,0 +1,40 @@
+// Package example contains types to test the code generated by object-gen.
+package example
+
+import (
+	"time"
+)
+
+//go:generate go run github.com/xaionaro-go/object/cmd/object-gen -type=Config,Node
+
+type Credentials struct {
+	Login    string
+	Password string `secret:""`
+}
+
+type Config struct {
+	Name        string
+	Credentials *Credentials
+	Login       *string
+	Backups     []Credentials
+	Tags        map[string][]string
+	Owners      map[Credentials]string
+	Schedule    map[time.Time]string
+	Nodes       [2]*Node
+	Extra       any
+	Token       []byte `secret:""`
+	UpdatedAt   time.Time
+	Cache       map[string][]byte `object:"shallow"`
+	Scratch     []byte            `object:"-"`
+	internal    string
+	revision    int `object:"copy=unexported"`
+}
+
+type Node struct {
+	ID       int
+	Parent   *Node
+	Children []*Node
+	Data     map[string]any
+	Labels   map[string]interface{}
+	Secret   string `secret:""`
+}
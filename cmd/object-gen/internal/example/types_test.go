@@ -0,0 +1,96 @@
+package example
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xaionaro-go/object"
+)
+
+func sample() Config {
+	root := &Node{ID: 1, Secret: "root secret"}
+	child := &Node{ID: 2, Parent: root, Data: map[string]any{
+		"node":  Node{ID: 3, Secret: "in interface"},
+		"bytes": []byte{1, 2},
+	}, Labels: map[string]interface{}{"label": Node{ID: 4}}}
+	root.Children = []*Node{child, child}
+	creds := &Credentials{Login: "login", Password: "password"}
+	return Config{
+		Name:        "name",
+		Credentials: creds,
+		Backups:     []Credentials{*creds},
+		Tags:        map[string][]string{"a": {"b"}},
+		Owners:      map[Credentials]string{*creds: "owner"},
+		Schedule:    map[time.Time]string{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC): "backup"},
+		Nodes:       [2]*Node{root, child},
+		Extra:       creds,
+		Login:       &creds.Login,
+		Token:       []byte{3, 4},
+		UpdatedAt:   time.Now(),
+		Cache:       map[string][]byte{"a": {5}},
+		Scratch:     []byte{6},
+		internal:    "internal",
+		revision:    7,
+	}
+}
+
+// noopVisitor forces object.DeepCopy to use reflection instead of the generated methods.
+var noopVisitor = object.OptionWithVisitorFunc(func(
+	_ *object.ProcContext,
+	v reflect.Value,
+	_ *reflect.StructField,
+) (reflect.Value, bool, error) {
+	return v, true, nil
+})
+
+func TestGeneratedMatchesReflection(t *testing.T) {
+	src := sample()
+
+	require.Equal(t, object.DeepCopy(src, noopVisitor), src.DeepCopy())
+	require.Equal(t, object.DeepCopyWithoutSecrets(src, noopVisitor), src.DeepCopyWithoutSecrets())
+	require.Equal(t, src.DeepCopy(), object.DeepCopy(src))
+	require.Equal(t, src.DeepCopyWithoutSecrets(), object.DeepCopyWithoutSecrets(src))
+
+	result := src.DeepCopy()
+	require.True(t, result.Nodes[0].Children[0] == result.Nodes[1])
+	require.True(t, result.Nodes[1].Parent == result.Nodes[0])
+	require.False(t, result.Nodes[0] == src.Nodes[0])
+	require.False(t, result.Credentials == src.Credentials)
+	require.Equal(t, reflect.ValueOf(src.Cache).Pointer(), reflect.ValueOf(result.Cache).Pointer())
+	require.Nil(t, result.Scratch)
+	require.Empty(t, result.internal)
+	require.Equal(t, 7, result.revision)
+
+	// pointers reachable through interfaces keep their identity
+	for _, result := range []Config{result, object.DeepCopy(src, noopVisitor), src.DeepCopyWithoutSecrets()} {
+		require.True(t, result.Extra.(*Credentials) == result.Credentials)
+		require.True(t, result.Login == &result.Credentials.Login)
+	}
+}
+
+func TestGeneratedErrors(t *testing.T) {
+	src := Config{
+		Owners: map[Credentials]string{
+			{Login: "a", Password: "1"}: "first",
+			{Login: "a", Password: "2"}: "second",
+		},
+	}
+
+	_, err := object.TryDeepCopyWithoutSecrets(src)
+	var pathErr *object.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.ErrorIs(t, err, object.ErrMapKeyCollision)
+	require.Panics(t, func() { object.DeepCopyWithoutSecrets(src) })
+	require.PanicsWithValue(t, object.ErrMapKeyCollision, func() { src.DeepCopyWithoutSecrets() })
+
+	// other policies are handled by reflection
+	result, err := object.TryDeepCopyWithoutSecrets(src, object.OptionMapKeyCollision(object.MapKeyCollisionDrop))
+	require.NoError(t, err)
+	require.Empty(t, result.Owners)
+
+	result, err = object.TryDeepCopy(src)
+	require.NoError(t, err)
+	require.Equal(t, src, result)
+}
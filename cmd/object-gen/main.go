@@ -0,0 +1,77 @@
+// object-gen generates reflection-free `DeepCopy() T` and
+// `DeepCopyWithoutSecrets() T` methods for the given types.
+//
+// The generated methods behave the same way as object.DeepCopy and
+// object.DeepCopyWithoutSecrets (with the default options) do, and
+// these functions automatically use the generated methods when they are
+// available.
+//
+// The differences from object.DeepCopy:
+//   - if two different keys of a map become equal, the generated methods
+//     panic with object.ErrMapKeyCollision (object.DeepCopy uses the
+//     generated methods only with the default object.MapKeyCollisionError
+//     policy, see object.OptionMapKeyCollision);
+//   - the values of types which cannot be copied by the generated code
+//     (for example, unexported types of other packages, or values inside
+//     interfaces of types which are not copied by the generated code) are
+//     copied by a separate call of object.DeepCopy, so the pointers
+//     they share with the rest of the value are not shared by the copies.
+//
+// Usage:
+//
+//	//go:generate go run github.com/xaionaro-go/object/cmd/object-gen -type=MyType,MyOtherType
+//
+// All the types of a package should be listed in a single invocation,
+// since the generated file declares package-level helpers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultOutputFile = "objectgen_deepcopy.go"
+
+func main() {
+	typesFlag := flag.String("type", "", "comma-separated list of type names; required")
+	outputFlag := flag.String("output", "", "output file name; default: <dir>/"+defaultOutputFile)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -type=T1[,T2...] [-output=file] [dir]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *typesFlag == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		dir = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	output := *outputFlag
+	if output == "" {
+		output = filepath.Join(dir, defaultOutputFile)
+	}
+
+	src, err := generate(dir, strings.Split(*typesFlag, ","), filepath.Base(output))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "object-gen: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "object-gen: unable to write '%s': %v\n", output, err)
+		os.Exit(1)
+	}
+}
@@ -1,8 +1,10 @@
 package object
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 
 	"github.com/xaionaro-go/unsafetools"
 )
@@ -10,23 +12,183 @@ import (
 // DeepCopy returns a deep copy of the object.
 //
 // Keep in mind, by default it does not copy unexported data (unless
-// option `WithUnexported(true)` is provided).
+// option `WithUnexported(true)` is provided). The exception is the keys
+// of maps: they are copied completely, otherwise different keys could
+// become equal.
+//
+// The copying of a struct field could be controlled with tag `object:"..."`:
+//   - `object:"-"` leaves the field zero;
+//   - `object:"shallow"` copies the field as is, without recursion;
+//   - `object:"copy=unexported"` copies the unexported field even
+//     without option `WithUnexported(true)`.
+//
+// If no visitor function is provided, then the copying is performed
+// using a per-type plan, which is built on the first call and cached
+// for all the consequent calls (from any goroutine). And if also
+// `T` implements GeneratedCopier (see cmd/object-gen), then
+// the generated method is used instead of reflection at all.
+//
+// It panics on any error, see TryDeepCopy for the non-panicking version.
 func DeepCopy[T any](
 	obj T,
 	opts ...Option,
 ) T {
+	result, err := TryDeepCopy(obj, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// TryDeepCopy is the same as DeepCopy, but returns an error instead of panicking.
+//
+// The error (unless it is a bug in this package) is a *PathError.
+func TryDeepCopy[T any](
+	obj T,
+	opts ...Option,
+) (T, error) {
 	cfg := Options(opts).config()
+	if cfg.isDefaultCopy() {
+		if obj, ok := any(obj).(GeneratedCopier[T]); ok {
+			return callGeneratedCopier(obj.DeepCopy)
+		}
+	}
+	c := newDeepCopier(cfg)
+	c.prepare(reflect.ValueOf(&obj).Elem())
+	if cfg.VisitorFunc == nil {
+		// the fast path: using a precompiled plan (see copyPlan).
+		var result T
+		err := c.deepCopyByPlan(reflect.ValueOf(&result).Elem(), reflect.ValueOf(&obj).Elem())
+		if err != nil {
+			var zeroValue T
+			return zeroValue, err
+		}
+		return result, nil
+	}
+	ctx := newProcContext()
 	v := reflect.ValueOf(&obj)
-	result, _, err := newDeepCopier(cfg).deepCopy(v, newProcContext(), nil)
+	result, _, err := c.deepCopy(v, ctx, nil)
 	if err != nil {
+		var zeroValue T
+		return zeroValue, err
+	}
+	resultPtr, ok := result.Interface().(*T)
+	if !ok {
+		var zeroValue T
+		return zeroValue, newPathError(ctx, v.Type(), fmt.Errorf("received a value of a wrong type: expected:%s, received:%s", v.Type(), result.Type()))
+	}
+	return *resultPtr, nil
+}
+
+// DeepCopyInto writes a deep copy of `src` into `*dst`.
+//
+// Unlike DeepCopy it reuses the allocations already referenced by `*dst`
+// where the shapes match: slices with enough capacity, maps (they are
+// cleared and refilled) and the values behind pointers. So it is useful
+// for periodically copying the same object.
+//
+// The allocations are reused only if no visitor function is provided
+// (otherwise it is equivalent to `*dst = DeepCopy(src, opts...)`).
+// `*dst` must not share memory with `src`, and the same memory is never
+// reused twice (so if two pointers of `*dst` point to overlapping memory,
+// for example to a slice element and to the slice, or into `*dst`
+// itself, the second one gets a new allocation).
+//
+// It panics on any error, see TryDeepCopyInto for the non-panicking version.
+func DeepCopyInto[T any](
+	dst *T,
+	src T,
+	opts ...Option,
+) {
+	if err := TryDeepCopyInto(dst, src, opts...); err != nil {
 		panic(err)
 	}
-	return *result.Interface().(*T)
+}
+
+// TryDeepCopyInto is the same as DeepCopyInto, but returns an error
+// instead of panicking. On error `*dst` may be partially overwritten.
+//
+// The error (unless it is a bug in this package) is a *PathError.
+func TryDeepCopyInto[T any](
+	dst *T,
+	src T,
+	opts ...Option,
+) error {
+	cfg := Options(opts).config()
+	if cfg.VisitorFunc != nil {
+		result, err := TryDeepCopy(src, opts...)
+		if err != nil {
+			return err
+		}
+		*dst = result
+		return nil
+	}
+
+	c := newDeepCopier(cfg)
+	c.ReuseDestination = true
+	// `*dst` itself is overwritten, so the pointers into it cannot be reused
+	dstPtr := reflect.ValueOf(dst)
+	c.reuseAllocation(dstPtr.Pointer(), dstPtr.Type().Elem().Size())
+	c.prepare(reflect.ValueOf(&src).Elem())
+	return c.deepCopyByPlan(reflect.ValueOf(dst).Elem(), reflect.ValueOf(&src).Elem())
+}
+
+// GeneratedCopier is implemented by types with deep copy methods
+// generated by cmd/object-gen. DeepCopy and DeepCopyWithoutSecrets use
+// these methods instead of reflection, when no options that change
+// the copying behavior are provided.
+type GeneratedCopier[T any] interface {
+	DeepCopy() T
+	DeepCopyWithoutSecrets() T
+
+	// ObjectGenerated is a no-op marker, it distinguishes the generated
+	// methods from hand-written ones (which may call DeepCopy themselves).
+	ObjectGenerated()
+}
+
+// callGeneratedCopier calls a method of GeneratedCopier and converts
+// its panic (the generated code panics on errors, like DeepCopy does)
+// into a *PathError.
+func callGeneratedCopier[T any](copyFn func() T) (result T, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr, ok := r.(error)
+		if _, isRuntimeError := r.(runtime.Error); !ok || isRuntimeError {
+			panic(r)
+		}
+		var pathErr *PathError
+		if !errors.As(panicErr, &pathErr) {
+			pathErr = &PathError{
+				Type: reflect.TypeOf((*T)(nil)).Elem(),
+				Err:  panicErr,
+			}
+		}
+		err = pathErr
+	}()
+	return copyFn(), nil
 }
 
 type deepCopier struct {
 	config                     config
-	copiedValuesBehindPointers map[uintptr]reflect.Value
+	copiedValuesBehindPointers map[pointerKey]reflect.Value
+	sliceExtents               sliceExtents
+
+	// copiedRegions indexes copiedValuesBehindPointers by addresses, it is
+	// built only if copiedRegionsIndexed (see indexCopiedRegions). Until
+	// then copiedPointeeTypes (backed by copiedPointeeTypesBuf to save
+	// an allocation) are collected to find out if it is needed.
+	copiedRegions         map[uintptr][]copiedRegion
+	copiedRegionsIndexed  bool
+	copiedPointeeTypes    []reflect.Type
+	copiedPointeeTypesBuf [4]reflect.Type
+
+	// ReuseDestination enables reusing the allocations of the destination
+	// value (see DeepCopyInto).
+	ReuseDestination bool
+	reusedRegions    map[uintptr][]reusedRegion
 }
 
 func newDeepCopier(cfg config) *deepCopier {
@@ -35,20 +197,58 @@ func newDeepCopier(cfg config) *deepCopier {
 	}
 }
 
+// prepare should be called before copying `src` (and only once).
+func (c *deepCopier) prepare(src reflect.Value) {
+	if c.config.PreserveSliceAliasing {
+		c.sliceExtents = collectSliceExtents(src, c.config.ProcessUnexported)
+		// the backing arrays are always tracked
+		c.copiedRegionsIndexed = true
+	}
+}
+
+func (c *deepCopier) deepCopyByPlan(
+	dst reflect.Value,
+	src reflect.Value,
+) error {
+	err := getCopyPlan(src.Type(), c.config.ProcessUnexported).Copy(c, dst, src)
+	if err != nil {
+		// to be consistent with the paths of deepCopy, which starts with a pointer
+		return withPathPrefix(err, "*")
+	}
+	return nil
+}
+
+// visit calls the visitor function (if any) on the node.
+func (c *deepCopier) visit(
+	v reflect.Value,
+	ctx *ProcContext,
+	structField *reflect.StructField,
+) (reflect.Value, bool, error) {
+	if c.config.VisitorFunc == nil {
+		return v, true, nil
+	}
+	newV, goDeeper, err := c.config.VisitorFunc(ctx, v, structField)
+	if err != nil {
+		return newV, goDeeper, newPathError(ctx, v.Type(), fmt.Errorf("got an error from the visitor function: %w", err))
+	}
+	return newV, goDeeper, nil
+}
+
 func (c *deepCopier) deepCopy(
 	v reflect.Value,
 	ctx *ProcContext,
 	structField *reflect.StructField,
 ) (reflect.Value, bool, error) {
-	if c.config.VisitorFunc != nil {
-		newV, goDeeper, err := c.config.VisitorFunc(ctx, v, structField)
-		v = newV
-		if err != nil {
-			return v, goDeeper, fmt.Errorf("got an error from the visitor function at '%s': %w", ctx.path, err)
-		}
-		if !goDeeper {
-			return v, false, nil
-		}
+	v, goDeeper, err := c.visit(v, ctx, structField)
+	if err != nil {
+		return v, goDeeper, err
+	}
+	if !goDeeper {
+		return v, false, nil
+	}
+
+	if !v.IsValid() {
+		return v, false, newPathError(ctx, nil, fmt.Errorf("received an invalid value from the visitor function"))
 	}
 
 	t := v.Type()
@@ -92,11 +292,14 @@ func (c *deepCopier) deepCopy(
 		result.Set(v)
 	case reflect.Array:
 		for i := 0; i < v.Len(); i++ {
-			c, _, err := c.deepCopy(v.Index(i), ctx.Next(fmt.Sprintf("[%d]", i)), nil)
+			idxCtx := ctx.Next(fmt.Sprintf("[%d]", i))
+			c, _, err := c.deepCopy(v.Index(i), idxCtx, nil)
 			if err != nil {
 				return reflect.Value{}, false, err
 			}
-			result.Index(i).Set(c)
+			if err := setValue(idxCtx, result.Index(i), c); err != nil {
+				return reflect.Value{}, false, err
+			}
 		}
 	case reflect.Chan:
 		result.Set(v)
@@ -106,99 +309,142 @@ func (c *deepCopier) deepCopy(
 		if !v.Elem().IsValid() { // if unwrapInterface(v) == nil { return v }
 			return v, false, nil
 		}
-		newV, _, err := c.deepCopy(v.Elem(), ctx.Next("{}"), nil)
+		elemCtx := ctx.Next("{}")
+		newV, _, err := c.deepCopy(v.Elem(), elemCtx, nil)
 		if err != nil {
 			return result, false, err
 		}
-		result.Set(newV)
+		if err := setValue(elemCtx, result, newV); err != nil {
+			return result, false, err
+		}
 	case reflect.Map:
 		if v.IsNil() {
 			return result, false, nil
 		}
 		result = reflect.MakeMapWithSize(t, v.Len())
+		filler := mapFiller{Map: result, Policy: c.config.MapKeyCollisionPolicy}
 		iter := v.MapRange()
 		for iter.Next() {
 			k := iter.Key()
 			v := iter.Value()
-			newV, _, err := c.deepCopy(v, ctx.Next(fmt.Sprintf("[%v]", k)), nil)
+			keyCtx := ctx.nextMapKey(k)
+			newK, _, err := c.deepCopy(k, keyCtx, nil)
+			if err != nil {
+				return result, false, err
+			}
+			if !newK.IsValid() || !newK.Type().AssignableTo(t.Key()) {
+				return result, false, setValue(keyCtx, reflect.New(t.Key()).Elem(), newK)
+			}
+			valueCtx := ctx.Next(mapValuePathPart(k))
+			newV, _, err := c.deepCopy(v, valueCtx, nil)
 			if err != nil {
 				return result, false, err
 			}
-			result.SetMapIndex(k, newV)
+			if !newV.IsValid() || !newV.Type().AssignableTo(t.Elem()) {
+				return result, false, setValue(valueCtx, reflect.New(t.Elem()).Elem(), newV)
+			}
+			if err := filler.set(newK, newV); err != nil {
+				return result, false, newPathError(keyCtx, t.Key(), err)
+			}
 		}
+		filler.finish()
 	case reflect.Pointer:
 		if v.IsNil() {
 			return result, false, nil
 		}
-		ptr := v.Pointer()
-		if c.copiedValuesBehindPointers == nil {
-			c.copiedValuesBehindPointers = make(map[uintptr]reflect.Value)
+		if copied, ok := c.lookupCopiedPointer(v); ok {
+			return copied, false, nil
 		}
-		if v, ok := c.copiedValuesBehindPointers[ptr]; ok {
-			return v, false, nil
-		}
-		c.copiedValuesBehindPointers[ptr] = result
-		result.Set(reflect.New(t.Elem())) // result = &T{}
-		newVElem, _, err := c.deepCopy(v.Elem(), ctx.Next("*"), nil)
+		newPtr := reflect.New(t.Elem())
+		c.registerCopiedPointer(v, newPtr)
+		result.Set(newPtr) // result = &T{}
+		elemCtx := ctx.Next("*")
+		newVElem, _, err := c.deepCopy(v.Elem(), elemCtx, nil)
 		if err != nil {
 			return result, false, err
 		}
-		result.Elem().Set(newVElem) // *result = *v
+		if err := setValue(elemCtx, result.Elem(), newVElem); err != nil { // *result = *v
+			return result, false, err
+		}
 	case reflect.Slice:
 		if v.IsNil() {
 			return result, false, nil
 		}
+		if c.config.PreserveSliceAliasing {
+			err := c.copySliceWithAliasing(result, v, func(dstArray, srcArray reflect.Value, offset int) error {
+				for i := 0; i < srcArray.Len(); i++ {
+					idxCtx := ctx.Next(sliceIndexPathPart(i, offset))
+					newV, _, err := c.deepCopy(srcArray.Index(i), idxCtx, nil)
+					if err != nil {
+						return err
+					}
+					if err := setValue(idxCtx, dstArray.Index(i), newV); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			return result, false, err
+		}
 		result = reflect.MakeSlice(t, v.Len(), v.Len())
 		for i := 0; i < v.Len(); i++ {
-			newV, _, err := c.deepCopy(v.Index(i), ctx.Next(fmt.Sprintf("[%d]", i)), nil)
+			idxCtx := ctx.Next(fmt.Sprintf("[%d]", i))
+			newV, _, err := c.deepCopy(v.Index(i), idxCtx, nil)
 			if err != nil {
 				return result, false, err
 			}
-			result.Index(i).Set(newV)
+			if err := setValue(idxCtx, result.Index(i), newV); err != nil {
+				return result, false, err
+			}
 		}
 	case reflect.String:
 		result.Set(v)
 	case reflect.Struct:
+		policies := getFieldPolicies(t)
 		for i := 0; i < v.NumField(); i++ {
 			fV := v.Field(i)
 			fT := t.Field(i)
+			policy := &policies[i]
+			fieldCtx := ctx.Next(fT.Name)
 
+			if policy.Err != nil {
+				return result, false, newPathError(fieldCtx, fT.Type, policy.Err)
+			}
+			// the unexported fields of map keys are always copied,
+			// otherwise different keys may become equal.
+			if !policy.isCopied(fT.PkgPath == "", c.config.ProcessUnexported || ctx.InMapKey()) {
+				continue
+			}
 			if fT.PkgPath != "" {
-				if !c.config.ProcessUnexported {
-					// unexported
-					continue
-				}
 				if !v.CanAddr() {
 					vWithAddr := reflect.New(v.Type()).Elem()
 					vWithAddr.Set(v)
-					if v.Type() != vWithAddr.Type() {
-						panic(fmt.Errorf("internal error: received wrong type at '%s': expected:%s, received:%s", ctx.path, v.Type(), vWithAddr.Type()))
-					}
 					v = vWithAddr
 				}
-				fVWithAddr := unsafetools.FieldByIndexInValue(v.Addr(), i).Elem()
-				if fV.Type() != fVWithAddr.Type() {
-					panic(fmt.Errorf("internal error: received wrong type at '%s': expected:%s, received:%s", ctx.path, fV.Type(), fVWithAddr.Type()))
-				}
-				fV = fVWithAddr
+				fV = unsafetools.FieldByIndexInValue(v.Addr(), i).Elem()
 			}
 
-			newFV, _, err := c.deepCopy(fV, ctx.Next(fT.Name), &fT)
+			var newFV reflect.Value
+			var err error
+			if policy.Shallow {
+				newFV, _, err = c.visit(fV, fieldCtx, &fT)
+			} else {
+				newFV, _, err = c.deepCopy(fV, fieldCtx, &fT)
+			}
 			if err != nil {
 				return result, false, err
 			}
 			outF := result.Field(i)
-			if outF.Type() != newFV.Type() {
-				panic(fmt.Errorf("received wrong type at '%s': expected:%s, received:%s", ctx.path, outF.Type(), newFV.Type()))
-			}
 			if fT.PkgPath != "" {
 				// unexported
 				outF = unsafetools.FieldByIndexInValue(result.Addr(), i).Elem()
 			}
-			outF.Set(newFV)
+			if err := setValue(fieldCtx, outF, newFV); err != nil {
+				return result, false, err
+			}
 		}
 	default:
-		panic(fmt.Errorf("unexpected kind: %v", v.Kind()))
+		return result, false, newPathError(ctx, t, fmt.Errorf("unexpected kind: %v", v.Kind()))
 	}
 	return result, false, nil
 }
@@ -206,42 +452,42 @@ func (c *deepCopier) deepCopy(
 // DeepCopyWithoutSecrets returns a deep copy of the object, but with all
 // fields tagged as `secret:""` reset to their zero values.
 //
-// Keep in mind, this function does not censor:
-// * the internals of: channels, function values, uintptr-s and unsafe.Pointer-s;
-// * the keys of maps.
+// Keep in mind, this function does not censor the internals of:
+// channels, function values, uintptr-s and unsafe.Pointer-s.
+//
+// The keys of maps are censored as well. If two keys of a map become
+// equal, then the behavior is defined by OptionMapKeyCollision.
 //
 // Also, it does not copy unexported data.
+//
+// The visitor functions (see OptionWithVisitorFunc) are called before
+// the secrets are removed.
+//
+// It panics on any error, see TryDeepCopyWithoutSecrets for the non-panicking version.
 func DeepCopyWithoutSecrets[T any](
 	obj T,
 	opts ...Option,
 ) T {
-	cfg := Options(opts).config()
-	return DeepCopy(
-		obj,
-		OptionWithVisitorFunc(func(
-			ctx *ProcContext,
-			v reflect.Value,
-			sf *reflect.StructField,
-		) (reflect.Value, bool, error) {
-			goDeeper := true
-			if cfg.VisitorFunc != nil {
-				var err error
-				v, goDeeper, err = cfg.VisitorFunc(ctx, v, sf)
-				if err != nil {
-					return v, goDeeper, err
-				}
-			}
-
-			if sf == nil {
-				return v, goDeeper, nil
-			}
-
-			if _, ok := sf.Tag.Lookup("secret"); !ok {
-				return v, goDeeper, nil
-			}
+	result, err := TryDeepCopyWithoutSecrets(obj, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
 
-			return reflect.Zero(v.Type()), goDeeper, nil
-		}),
-		OptionWithUnexported(cfg.ProcessUnexported),
-	)
+// TryDeepCopyWithoutSecrets is the same as DeepCopyWithoutSecrets,
+// but returns an error instead of panicking.
+//
+// The error (unless it is a bug in this package) is a *PathError.
+func TryDeepCopyWithoutSecrets[T any](
+	obj T,
+	opts ...Option,
+) (T, error) {
+	cfg := Options(opts).config()
+	if cfg.isDefaultCopy() {
+		if obj, ok := any(obj).(GeneratedCopier[T]); ok {
+			return callGeneratedCopier(obj.DeepCopyWithoutSecrets)
+		}
+	}
+	return TryDeepCopy(obj, append(opts[:len(opts):len(opts)], OptionWithVisitorFunc(removeSecretsVisitorFunc))...)
 }
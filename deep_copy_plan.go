@@ -0,0 +1,463 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// copyPlan is a precompiled recipe of how to deep copy values of a specific type.
+//
+// Plans are built once per type (see getCopyPlan) and then reused across
+// DeepCopy calls and goroutines, so that the type does not need to be
+// re-inspected on every call.
+type copyPlan struct {
+	// Copy writes a deep copy of `src` into `dst`, completely overwriting
+	// the previous value of `dst`. `dst` must be addressable.
+	//
+	// If deepCopier.ReuseDestination is true, then the allocations
+	// (slices, maps, pointees) referenced by `dst` are reused when possible.
+	Copy func(c *deepCopier, dst, src reflect.Value) error
+}
+
+type copyPlanKey struct {
+	Type              reflect.Type
+	ProcessUnexported bool
+}
+
+var copyPlans sync.Map // copyPlanKey -> *copyPlan
+
+func getCopyPlan(t reflect.Type, processUnexported bool) *copyPlan {
+	if plan, ok := copyPlans.Load(copyPlanKey{Type: t, ProcessUnexported: processUnexported}); ok {
+		return plan.(*copyPlan)
+	}
+
+	b := &copyPlanBuilder{
+		ProcessUnexported: processUnexported,
+		Plans:             map[reflect.Type]*copyPlan{},
+	}
+	plan := b.build(t)
+
+	// publishing only fully built plans, so that other goroutines never
+	// see a plan with a nil Copy function.
+	for t, plan := range b.Plans {
+		copyPlans.LoadOrStore(copyPlanKey{Type: t, ProcessUnexported: processUnexported}, plan)
+	}
+	return plan
+}
+
+type copyPlanBuilder struct {
+	ProcessUnexported bool
+	Plans             map[reflect.Type]*copyPlan
+}
+
+func (b *copyPlanBuilder) build(t reflect.Type) *copyPlan {
+	if plan, ok := copyPlans.Load(copyPlanKey{Type: t, ProcessUnexported: b.ProcessUnexported}); ok {
+		return plan.(*copyPlan)
+	}
+	if plan, ok := b.Plans[t]; ok {
+		// recursive type, the plan is still being built; it is OK since
+		// the Copy function is dereferenced only during the copying.
+		return plan
+	}
+
+	plan := &copyPlan{}
+	b.Plans[t] = plan
+	plan.Copy = b.buildCopyFunc(t)
+	return plan
+}
+
+// isBulkCopyable returns true if a value of type `t` could be deep copied
+// by a simple assignment (it contains no references to mutable data
+// and nothing that needs to be skipped).
+func (b *copyPlanBuilder) isBulkCopyable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() == 0 || b.isBulkCopyable(t.Elem())
+	case reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return false
+	case reflect.Struct:
+		policies := getFieldPolicies(t)
+		for i := 0; i < t.NumField(); i++ {
+			fT := t.Field(i)
+			policy := &policies[i]
+			if policy.Err != nil || !policy.isCopied(fT.PkgPath == "", b.ProcessUnexported) {
+				// skipped fields should be left zero, so cannot just copy the whole struct
+				return false
+			}
+			if policy.Shallow {
+				continue
+			}
+			if !b.isBulkCopyable(fT.Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		// We assume that if somebody uses uintptr or/and unsafe.Pointer
+		// then they take all the responsibility for whatever happens,
+		// so we just copy as is. Same for channels and functions.
+		// And strings are immutable.
+		return true
+	}
+}
+
+func (b *copyPlanBuilder) buildCopyFunc(t reflect.Type) func(*deepCopier, reflect.Value, reflect.Value) error {
+	if b.isBulkCopyable(t) {
+		return copyBulk
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		return b.buildCopyArray(t)
+	case reflect.Interface:
+		return copyInterface
+	case reflect.Map:
+		return b.buildCopyMap(t)
+	case reflect.Pointer:
+		return b.buildCopyPointer(t)
+	case reflect.Slice:
+		return b.buildCopySlice(t)
+	case reflect.Struct:
+		return b.buildCopyStruct(t)
+	default:
+		panic("internal error: a non-bulk-copyable kind: " + t.Kind().String())
+	}
+}
+
+func copyBulk(_ *deepCopier, dst, src reflect.Value) error {
+	dst.Set(src)
+	return nil
+}
+
+func copyInterface(c *deepCopier, dst, src reflect.Value) error {
+	if src.IsNil() {
+		dst.SetZero()
+		return nil
+	}
+	elem := src.Elem()
+	newElem := reflect.New(elem.Type()).Elem()
+	if c.ReuseDestination && !dst.IsNil() && dst.Elem().Type() == elem.Type() {
+		newElem.Set(dst.Elem())
+	}
+	err := getCopyPlan(elem.Type(), c.config.ProcessUnexported).Copy(c, newElem, elem)
+	if err != nil {
+		return withPathPrefix(err, "{}")
+	}
+	dst.Set(newElem)
+	return nil
+}
+
+func (b *copyPlanBuilder) buildCopyArray(t reflect.Type) func(*deepCopier, reflect.Value, reflect.Value) error {
+	elemPlan := b.build(t.Elem())
+	length := t.Len()
+	return func(c *deepCopier, dst, src reflect.Value) error {
+		for i := 0; i < length; i++ {
+			if err := elemPlan.Copy(c, dst.Index(i), src.Index(i)); err != nil {
+				return withPathPrefix(err, fmt.Sprintf("[%d]", i))
+			}
+		}
+		return nil
+	}
+}
+
+func (b *copyPlanBuilder) buildCopyMap(t reflect.Type) func(*deepCopier, reflect.Value, reflect.Value) error {
+	// the unexported fields of keys are always copied,
+	// otherwise different keys may become equal.
+	keyBuilder := b
+	if !b.ProcessUnexported {
+		keyBuilder = &copyPlanBuilder{ProcessUnexported: true}
+	}
+	if !keyBuilder.isBulkCopyable(t.Key()) {
+		return b.buildCopyMapWithKeys(t, keyBuilder)
+	}
+
+	// the keys could be reused as is (and thus cannot collide).
+	if b.isBulkCopyable(t.Elem()) {
+		return func(c *deepCopier, dst, src reflect.Value) error {
+			if src.IsNil() {
+				dst.SetZero()
+				return nil
+			}
+			result := c.makeMap(dst, src)
+			iter := src.MapRange()
+			for iter.Next() {
+				result.SetMapIndex(iter.Key(), iter.Value())
+			}
+			dst.Set(result)
+			return nil
+		}
+	}
+
+	elemPlan := b.build(t.Elem())
+	return func(c *deepCopier, dst, src reflect.Value) error {
+		if src.IsNil() {
+			dst.SetZero()
+			return nil
+		}
+		result := c.makeMap(dst, src)
+		newV := reflect.New(t.Elem()).Elem()
+		iter := src.MapRange()
+		for iter.Next() {
+			if c.ReuseDestination {
+				// to do not reuse allocations of the previous item
+				newV.SetZero()
+			}
+			if err := elemPlan.Copy(c, newV, iter.Value()); err != nil {
+				return withPathPrefix(err, mapValuePathPart(iter.Key()))
+			}
+			result.SetMapIndex(iter.Key(), newV)
+		}
+		dst.Set(result)
+		return nil
+	}
+}
+
+// buildCopyMapWithKeys is the same as buildCopyMap, but for maps
+// with keys, which need to be deep copied.
+func (b *copyPlanBuilder) buildCopyMapWithKeys(
+	t reflect.Type,
+	keyBuilder *copyPlanBuilder,
+) func(*deepCopier, reflect.Value, reflect.Value) error {
+	var keyPlan *copyPlan
+	if keyBuilder == b {
+		keyPlan = b.build(t.Key())
+	} else {
+		keyPlan = getCopyPlan(t.Key(), keyBuilder.ProcessUnexported)
+	}
+	elemPlan := b.build(t.Elem())
+	return func(c *deepCopier, dst, src reflect.Value) error {
+		if src.IsNil() {
+			dst.SetZero()
+			return nil
+		}
+		result := c.makeMap(dst, src)
+		filler := mapFiller{Map: result, Policy: c.config.MapKeyCollisionPolicy}
+		iter := src.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			newK := reflect.New(t.Key()).Elem()
+			if err := keyPlan.Copy(c, newK, k); err != nil {
+				return withPathPrefix(err, mapKeyPathPart(k))
+			}
+			newV := reflect.New(t.Elem()).Elem()
+			if err := elemPlan.Copy(c, newV, iter.Value()); err != nil {
+				return withPathPrefix(err, mapValuePathPart(k))
+			}
+			if err := filler.set(newK, newV); err != nil {
+				return withPathPrefix(&PathError{Type: t.Key(), Err: err}, mapKeyPathPart(k))
+			}
+		}
+		filler.finish()
+		dst.Set(result)
+		return nil
+	}
+}
+
+func (b *copyPlanBuilder) buildCopyPointer(t reflect.Type) func(*deepCopier, reflect.Value, reflect.Value) error {
+	elemT := t.Elem()
+	elemPlan := b.build(elemT)
+	return func(c *deepCopier, dst, src reflect.Value) error {
+		if src.IsNil() {
+			dst.SetZero()
+			return nil
+		}
+		if v, ok := c.lookupCopiedPointer(src); ok {
+			dst.Set(v)
+			return nil
+		}
+		var newPtr reflect.Value
+		if !dst.IsNil() && c.reuseAllocation(dst.Pointer(), elemT.Size()) {
+			newPtr = reflect.NewAt(elemT, dst.UnsafePointer())
+		} else {
+			newPtr = reflect.New(elemT)
+		}
+		c.registerCopiedPointer(src, newPtr)
+		if err := elemPlan.Copy(c, newPtr.Elem(), src.Elem()); err != nil {
+			return withPathPrefix(err, "*")
+		}
+		dst.Set(newPtr)
+		return nil
+	}
+}
+
+func (b *copyPlanBuilder) buildCopySlice(t reflect.Type) func(*deepCopier, reflect.Value, reflect.Value) error {
+	if b.isBulkCopyable(t.Elem()) {
+		// for example []byte
+		return func(c *deepCopier, dst, src reflect.Value) error {
+			if c.config.PreserveSliceAliasing {
+				return c.copySliceWithAliasing(dst, src, func(dstArray, srcArray reflect.Value, _ int) error {
+					dstArray.Set(srcArray)
+					return nil
+				})
+			}
+			if src.IsNil() {
+				dst.SetZero()
+				return nil
+			}
+			result := c.makeSlice(dst, src)
+			reflect.Copy(result, src)
+			dst.Set(result)
+			return nil
+		}
+	}
+
+	elemPlan := b.build(t.Elem())
+	return func(c *deepCopier, dst, src reflect.Value) error {
+		if c.config.PreserveSliceAliasing {
+			return c.copySliceWithAliasing(dst, src, func(dstArray, srcArray reflect.Value, offset int) error {
+				for i := 0; i < srcArray.Len(); i++ {
+					if err := elemPlan.Copy(c, dstArray.Index(i), srcArray.Index(i)); err != nil {
+						return withPathPrefix(err, sliceIndexPathPart(i, offset))
+					}
+				}
+				return nil
+			})
+		}
+		if src.IsNil() {
+			dst.SetZero()
+			return nil
+		}
+		result := c.makeSlice(dst, src)
+		for i := 0; i < src.Len(); i++ {
+			if err := elemPlan.Copy(c, result.Index(i), src.Index(i)); err != nil {
+				return withPathPrefix(err, fmt.Sprintf("[%d]", i))
+			}
+		}
+		dst.Set(result)
+		return nil
+	}
+}
+
+type copyPlanField struct {
+	Index    int
+	Name     string
+	Offset   uintptr
+	Type     reflect.Type
+	Exported bool
+	Skip     bool
+	Plan     *copyPlan
+}
+
+func (f *copyPlanField) valueIn(structValue reflect.Value) reflect.Value {
+	if f.Exported {
+		return structValue.Field(f.Index)
+	}
+	// unexported
+	return reflect.NewAt(f.Type, unsafe.Add(unsafe.Pointer(structValue.UnsafeAddr()), f.Offset)).Elem()
+}
+
+func (b *copyPlanBuilder) buildCopyStruct(t reflect.Type) func(*deepCopier, reflect.Value, reflect.Value) error {
+	fields := make([]copyPlanField, t.NumField())
+	policies := getFieldPolicies(t)
+	needsAddr := false
+	for i := range fields {
+		fT := t.Field(i)
+		policy := &policies[i]
+		f := &fields[i]
+		f.Index = i
+		f.Name = fT.Name
+		f.Offset = fT.Offset
+		f.Type = fT.Type
+		f.Exported = fT.PkgPath == ""
+		if !policy.isCopied(f.Exported, b.ProcessUnexported) {
+			f.Skip = true
+			continue
+		}
+		if !f.Exported {
+			needsAddr = true
+		}
+		switch {
+		case policy.Err != nil:
+			fieldType, err := fT.Type, policy.Err
+			f.Plan = &copyPlan{Copy: func(*deepCopier, reflect.Value, reflect.Value) error {
+				return &PathError{Type: fieldType, Err: err}
+			}}
+		case policy.Shallow:
+			f.Plan = &copyPlan{Copy: copyBulk}
+		default:
+			f.Plan = b.build(fT.Type)
+		}
+	}
+
+	return func(c *deepCopier, dst, src reflect.Value) error {
+		if needsAddr && !src.CanAddr() {
+			srcWithAddr := reflect.New(t).Elem()
+			srcWithAddr.Set(src)
+			src = srcWithAddr
+		}
+		for idx := range fields {
+			f := &fields[idx]
+			dstF := f.valueIn(dst)
+			if f.Skip {
+				dstF.SetZero()
+				continue
+			}
+			if err := f.Plan.Copy(c, dstF, f.valueIn(src)); err != nil {
+				return withPathPrefix(err, f.Name)
+			}
+		}
+		return nil
+	}
+}
+
+// reusedRegion is a memory region of the destination value which is
+// reused, see reuseAllocation.
+type reusedRegion struct {
+	Start uintptr
+	End   uintptr
+}
+
+// reuseAllocation returns true if the memory [ptr, ptr+size) of the
+// destination value should be reused, and marks it as reused (so that
+// the same memory is never reused for two different values, even
+// partially: for example, for a slice and a pointer to its element).
+func (c *deepCopier) reuseAllocation(ptr, size uintptr) bool {
+	if !c.ReuseDestination {
+		return false
+	}
+	if size == 0 {
+		// nothing is going to be written there
+		return true
+	}
+	region := reusedRegion{
+		Start: ptr,
+		End:   ptr + size,
+	}
+	firstChunk, lastChunk := region.Start/copiedRegionsGranularity, (region.End-1)/copiedRegionsGranularity
+	for chunk := firstChunk; chunk <= lastChunk; chunk++ {
+		for _, reused := range c.reusedRegions[chunk] {
+			if region.Start < reused.End && reused.Start < region.End {
+				return false
+			}
+		}
+	}
+	if c.reusedRegions == nil {
+		c.reusedRegions = make(map[uintptr][]reusedRegion)
+	}
+	for chunk := firstChunk; chunk <= lastChunk; chunk++ {
+		c.reusedRegions[chunk] = append(c.reusedRegions[chunk], region)
+	}
+	return true
+}
+
+// makeMap returns an empty map to copy `src` to (either reused `dst` or a new one).
+func (c *deepCopier) makeMap(dst, src reflect.Value) reflect.Value {
+	// the internals of a map are not addressable, so it is enough to
+	// track just its identity
+	if !dst.IsNil() && c.reuseAllocation(dst.Pointer(), 1) {
+		dst.Clear()
+		return dst
+	}
+	return reflect.MakeMapWithSize(src.Type(), src.Len())
+}
+
+// makeSlice returns a slice of length `src.Len()` to copy `src` to
+// (either reused `dst` or a new one).
+func (c *deepCopier) makeSlice(dst, src reflect.Value) reflect.Value {
+	if !dst.IsNil() && dst.Cap() >= src.Len() && c.reuseAllocation(dst.Pointer(), uintptr(dst.Cap())*dst.Type().Elem().Size()) {
+		return dst.Slice(0, src.Len())
+	}
+	return reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+}
@@ -0,0 +1,276 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"unsafe"
+)
+
+// memoryRegion is a region [Start, End) of memory, which begins at pointer Base.
+type memoryRegion struct {
+	Start uintptr
+	End   uintptr
+	Base  unsafe.Pointer
+}
+
+// sliceExtents contains the memory regions of backing arrays of
+// all the slices of an object (per element type). Overlapping
+// slices share the same region.
+type sliceExtents map[reflect.Type][]memoryRegion
+
+// find returns the region which contains memory [start, start+size) of
+// a slice with elements of type `elemT`.
+func (s sliceExtents) find(elemT reflect.Type, start, size uintptr) (memoryRegion, bool) {
+	regions := s[elemT]
+	idx := sort.Search(len(regions), func(i int) bool {
+		return regions[i].End > start
+	})
+	if idx >= len(regions) {
+		return memoryRegion{}, false
+	}
+	region := regions[idx]
+	if start < region.Start || start+size > region.End {
+		return memoryRegion{}, false
+	}
+	return region, true
+}
+
+type sliceKey struct {
+	Data uintptr
+	Cap  int
+	Type reflect.Type
+}
+
+// sliceExtentsCollector walks through an object and collects sliceExtents.
+type sliceExtentsCollector struct {
+	ProcessUnexported bool
+	Extents           sliceExtents
+
+	visitedPointers  map[pointerKey]struct{}
+	visitedSlices    map[sliceKey]struct{}
+	visitedMaps      map[uintptr]struct{}
+	mayContainSlices map[reflect.Type]bool
+}
+
+func collectSliceExtents(
+	v reflect.Value,
+	processUnexported bool,
+) sliceExtents {
+	col := &sliceExtentsCollector{
+		ProcessUnexported: processUnexported,
+		Extents:           sliceExtents{},
+		visitedPointers:   map[pointerKey]struct{}{},
+		visitedSlices:     map[sliceKey]struct{}{},
+		visitedMaps:       map[uintptr]struct{}{},
+		mayContainSlices:  map[reflect.Type]bool{},
+	}
+	col.walk(v)
+
+	for elemT, regions := range col.Extents {
+		slices.SortFunc(regions, func(a, b memoryRegion) int {
+			switch {
+			case a.Start < b.Start:
+				return -1
+			case a.Start > b.Start:
+				return 1
+			}
+			return 0
+		})
+		merged := regions[:1]
+		for _, region := range regions[1:] {
+			last := &merged[len(merged)-1]
+			if region.Start < last.End {
+				last.End = max(last.End, region.End)
+				continue
+			}
+			merged = append(merged, region)
+		}
+		col.Extents[elemT] = merged
+	}
+	return col.Extents
+}
+
+func (col *sliceExtentsCollector) typeMayContainSlices(t reflect.Type) bool {
+	if result, ok := col.mayContainSlices[t]; ok {
+		return result
+	}
+	result := false
+	switch t.Kind() {
+	case reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		result = true
+	case reflect.Array:
+		result = col.typeMayContainSlices(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if col.typeMayContainSlices(t.Field(i).Type) {
+				result = true
+				break
+			}
+		}
+	}
+	col.mayContainSlices[t] = result
+	return result
+}
+
+func (col *sliceExtentsCollector) walk(v reflect.Value) {
+	if !col.typeMayContainSlices(v.Type()) {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			col.walk(v.Index(i))
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		col.walk(v.Elem())
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		if _, ok := col.visitedMaps[v.Pointer()]; ok {
+			return
+		}
+		col.visitedMaps[v.Pointer()] = struct{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			col.walk(iter.Key())
+			col.walk(iter.Value())
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if isTrackablePointer(v) {
+			key := newPointerKey(v)
+			if _, ok := col.visitedPointers[key]; ok {
+				return
+			}
+			col.visitedPointers[key] = struct{}{}
+		}
+		col.walk(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() || v.Cap() == 0 {
+			return
+		}
+		key := sliceKey{Data: v.Pointer(), Cap: v.Cap(), Type: v.Type().Elem()}
+		if _, ok := col.visitedSlices[key]; ok {
+			return
+		}
+		col.visitedSlices[key] = struct{}{}
+		elemSize := key.Type.Size()
+		if elemSize > 0 {
+			col.Extents[key.Type] = append(col.Extents[key.Type], memoryRegion{
+				Start: key.Data,
+				End:   key.Data + uintptr(v.Cap())*elemSize,
+				Base:  v.UnsafePointer(),
+			})
+		}
+		// the spare capacity is copied as well, so walking through it too
+		v = v.Slice(0, v.Cap())
+		for i := 0; i < v.Len(); i++ {
+			col.walk(v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		policies := getFieldPolicies(t)
+		for i := 0; i < v.NumField(); i++ {
+			fT := t.Field(i)
+			fV := v.Field(i)
+			policy := &policies[i]
+			if policy.Shallow || !policy.isCopied(fT.PkgPath == "", col.ProcessUnexported) {
+				// not deep copied
+				continue
+			}
+			if fT.PkgPath != "" {
+				if !v.CanAddr() {
+					vWithAddr := reflect.New(t).Elem()
+					vWithAddr.Set(v)
+					v = vWithAddr
+				}
+				fV = reflect.NewAt(fT.Type, unsafe.Add(unsafe.Pointer(v.UnsafeAddr()), fT.Offset)).Elem()
+			}
+			col.walk(fV)
+		}
+	}
+}
+
+// sliceHeader is the memory layout of a slice.
+type sliceHeader struct {
+	Data unsafe.Pointer
+	Len  int
+	Cap  int
+}
+
+// sliceAt returns a slice of type `t` with backing array at `data`.
+func sliceAt(t reflect.Type, data unsafe.Pointer, length, capacity int) reflect.Value {
+	return reflect.NewAt(t, unsafe.Pointer(&sliceHeader{
+		Data: data,
+		Len:  length,
+		Cap:  capacity,
+	})).Elem()
+}
+
+// copySliceWithAliasing copies slice `src` into `dst` reproducing
+// the length, the capacity and the sharing of the backing array with
+// other slices (see OptionPreserveSliceAliasing).
+//
+// If the backing array is not copied yet, then `copyArray` is called
+// to copy it, where `offset` is the index of the first element of `src`
+// in the backing array.
+func (c *deepCopier) copySliceWithAliasing(
+	dst reflect.Value,
+	src reflect.Value,
+	copyArray func(dstArray, srcArray reflect.Value, offset int) error,
+) error {
+	if src.IsNil() {
+		dst.SetZero()
+		return nil
+	}
+	t := src.Type()
+	elemT := t.Elem()
+	elemSize := elemT.Size()
+	if src.Cap() == 0 || elemSize == 0 {
+		// nothing could be shared
+		dst.Set(reflect.MakeSlice(t, src.Len(), src.Cap()))
+		return nil
+	}
+
+	start := src.Pointer()
+	size := uintptr(src.Cap()) * elemSize
+	copyPtr, ok := c.lookupCopiedRegion(start, size)
+	if !ok {
+		region, ok := c.sliceExtents.find(elemT, start, size)
+		if !ok {
+			// for example, a slice provided by the visitor function
+			region = memoryRegion{
+				Start: start,
+				End:   start + size,
+				Base:  src.UnsafePointer(),
+			}
+		}
+		arrayT := reflect.ArrayOf(int((region.End-region.Start)/elemSize), elemT)
+		newArray := reflect.New(arrayT)
+		c.registerCopiedRegion(region.Start, arrayT, newArray.UnsafePointer())
+		offset := int((start - region.Start) / elemSize)
+		err := copyArray(newArray.Elem(), reflect.NewAt(arrayT, region.Base).Elem(), offset)
+		if err != nil {
+			return err
+		}
+		copyPtr = unsafe.Add(newArray.UnsafePointer(), start-region.Start)
+	}
+
+	dst.Set(sliceAt(t, copyPtr, src.Len(), src.Cap()))
+	return nil
+}
+
+// sliceIndexPathPart returns the path part (see ProcContext.Path)
+// of an element of a backing array, relative to the slice.
+func sliceIndexPathPart(arrayIdx int, offset int) string {
+	return fmt.Sprintf("[%d]", arrayIdx-offset)
+}
@@ -1,9 +1,13 @@
 package object
 
 import (
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -68,7 +72,7 @@ func testSampleWithoutSecrets() *testType {
 	return &testType{
 		SomeSlice: nil,
 		SomeMap: map[mapKey]testType{
-			{1, 2}: {
+			{1, 0}: {
 				SomePublicString: "hello",
 			},
 		},
@@ -104,3 +108,458 @@ func TestComplexStructureWithUnexported(t *testing.T) {
 	sampleWithoutSecrets := testSampleWithoutSecrets()
 	require.Equal(t, sampleWithoutSecrets, DeepCopyWithoutSecrets(sample, OptionWithUnexported(true)))
 }
+
+type bulkCopyableT struct {
+	A int
+	B [4]float64
+	C string
+}
+
+type planTestT struct {
+	Bytes    []byte
+	Bulk     []bulkCopyableT
+	Array    [2]*planTestT
+	Iface    any
+	Map      map[string][]byte
+	Next     *planTestT
+	internal []byte
+}
+
+func TestDeepCopyPlan(t *testing.T) {
+	sample := &planTestT{
+		Bytes:    []byte{1, 2, 3},
+		Bulk:     []bulkCopyableT{{A: 1, C: "c"}},
+		Iface:    &bulkCopyableT{A: 2},
+		Map:      map[string][]byte{"a": {4, 5}},
+		internal: []byte{6},
+	}
+	sample.Array[0] = &planTestT{Bytes: []byte{7}}
+	sample.Next = sample
+
+	t.Run("exported", func(t *testing.T) {
+		result := DeepCopy(sample)
+		require.Nil(t, result.internal)
+		require.Equal(t, sample.Bytes, result.Bytes)
+		require.Equal(t, sample.Bulk, result.Bulk)
+		require.Equal(t, sample.Iface, result.Iface)
+		require.Equal(t, sample.Map, result.Map)
+		require.Equal(t, sample.Array[0].Bytes, result.Array[0].Bytes)
+		require.True(t, result.Next == result)
+
+		result.Bytes[0] = 100
+		result.Bulk[0].A = 100
+		result.Map["a"][0] = 100
+		result.Iface.(*bulkCopyableT).A = 100
+		require.Equal(t, byte(1), sample.Bytes[0])
+		require.Equal(t, 1, sample.Bulk[0].A)
+		require.Equal(t, byte(4), sample.Map["a"][0])
+		require.Equal(t, 2, sample.Iface.(*bulkCopyableT).A)
+	})
+
+	t.Run("unexported", func(t *testing.T) {
+		result := DeepCopy(sample, OptionWithUnexported(true))
+		require.Equal(t, sample.internal, result.internal)
+		result.internal[0] = 100
+		require.Equal(t, byte(6), sample.internal[0])
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := DeepCopy(sample)
+				assert.Equal(t, sample.Bytes, result.Bytes)
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func BenchmarkDeepCopy(b *testing.B) {
+	sample := testSample()
+	b.Run("plan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			DeepCopy(sample)
+		}
+	})
+	b.Run("visitor", func(b *testing.B) {
+		visitor := OptionWithVisitorFunc(func(_ *ProcContext, v reflect.Value, _ *reflect.StructField) (reflect.Value, bool, error) {
+			return v, true, nil
+		})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			DeepCopy(sample, visitor)
+		}
+	})
+}
+
+func TestTryDeepCopy(t *testing.T) {
+	errVisitor := errors.New("visitor error")
+
+	t.Run("visitor-error", func(t *testing.T) {
+		_, err := TryDeepCopy(testSample(), OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			if sf != nil && sf.Name == "SomePublicString" {
+				return v, false, errVisitor
+			}
+			return v, true, nil
+		}))
+		require.ErrorIs(t, err, errVisitor)
+		var pathErr *PathError
+		require.ErrorAs(t, err, &pathErr)
+		require.Equal(t, ".*.*.SomeMap.[{1 2}].SomePublicString", pathErr.Path)
+		require.Equal(t, reflect.TypeOf(""), pathErr.Type)
+
+		require.Panics(t, func() {
+			DeepCopyWithoutSecrets(testSample(), OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+				return v, true, errVisitor
+			}))
+		})
+	})
+
+	t.Run("wrong-type", func(t *testing.T) {
+		_, err := TryDeepCopyWithoutSecrets(testSample(), OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			if sf != nil && sf.Name == "SomePublicString" {
+				return reflect.ValueOf(1), true, nil
+			}
+			return v, true, nil
+		}))
+		var pathErr *PathError
+		require.ErrorAs(t, err, &pathErr)
+		require.Equal(t, ".*.*.SomeMap.[{1 2}].SomePublicString", pathErr.Path)
+	})
+}
+
+func TestDeepCopyInto(t *testing.T) {
+	src := &planTestT{
+		Bytes: []byte{1, 2, 3},
+		Bulk:  []bulkCopyableT{{A: 1}},
+		Iface: &bulkCopyableT{A: 2},
+		Map:   map[string][]byte{"a": {4, 5}},
+		Next:  &planTestT{Bytes: []byte{6}},
+	}
+
+	var dst *planTestT
+	DeepCopyInto(&dst, src)
+	require.Equal(t, src, dst)
+
+	oldDst := *dst
+	oldNext := dst.Next
+	oldIface := dst.Iface
+	src.Bytes = src.Bytes[:2]
+	src.Bytes[0] = 10
+	src.Map["b"] = []byte{7}
+	src.Next.Bytes[0] = 11
+	src.Iface.(*bulkCopyableT).A = 12
+	DeepCopyInto(&dst, src)
+	require.Equal(t, src, dst)
+
+	require.True(t, &oldDst.Bytes[0] == &dst.Bytes[0])
+	require.Equal(t, reflect.ValueOf(oldDst.Map).Pointer(), reflect.ValueOf(dst.Map).Pointer())
+	require.True(t, oldNext == dst.Next)
+	require.True(t, oldIface == dst.Iface)
+
+	dst.Next.Bytes[0] = 100
+	require.Equal(t, byte(11), src.Next.Bytes[0])
+}
+
+type intoAliasesT struct {
+	S []int
+	P *int
+	A int
+}
+
+func TestDeepCopyIntoInteriorAliases(t *testing.T) {
+	x := 100
+	src := intoAliasesT{S: []int{1, 2, 3}, P: &x, A: 4}
+
+	t.Run("slice_element", func(t *testing.T) {
+		var dst intoAliasesT
+		dst.S = make([]int, 3)
+		dst.P = &dst.S[1]
+		DeepCopyInto(&dst, src)
+		require.Equal(t, src, dst)
+		require.Equal(t, []int{1, 2, 3}, dst.S)
+		require.False(t, dst.P == &dst.S[1])
+	})
+
+	t.Run("destination_itself", func(t *testing.T) {
+		var dst intoAliasesT
+		dst.P = &dst.A
+		DeepCopyInto(&dst, src)
+		require.Equal(t, src, dst)
+		require.Equal(t, 4, dst.A)
+		require.False(t, dst.P == &dst.A)
+	})
+}
+
+type innerT struct {
+	V int
+}
+
+type outerT struct {
+	Inner innerT
+	Other int
+}
+
+type interiorPointersT struct {
+	Outer *outerT
+	Inner *innerT
+	Other *int
+	Empty [2]*struct{}
+}
+
+func TestDeepCopyInteriorPointers(t *testing.T) {
+	outer := &outerT{Inner: innerT{V: 1}, Other: 2}
+	sample := interiorPointersT{
+		Outer: outer,
+		Inner: &outer.Inner,
+		Other: &outer.Other,
+		Empty: [2]*struct{}{{}, {}},
+	}
+
+	check := func(t *testing.T, result interiorPointersT) {
+		require.Equal(t, sample, result)
+		require.False(t, result.Outer == sample.Outer)
+		require.True(t, result.Inner == &result.Outer.Inner)
+		require.True(t, result.Other == &result.Outer.Other)
+	}
+
+	t.Run("plan", func(t *testing.T) {
+		check(t, DeepCopy(sample))
+	})
+	t.Run("visitor", func(t *testing.T) {
+		check(t, DeepCopy(sample, OptionWithVisitorFunc(func(_ *ProcContext, v reflect.Value, _ *reflect.StructField) (reflect.Value, bool, error) {
+			return v, true, nil
+		})))
+	})
+}
+
+type hiddenFieldT struct {
+	Pub     int
+	hidden  int
+	Skipped int `object:"-"`
+}
+
+type pointersToHiddenT struct {
+	Struct  *hiddenFieldT
+	Pub     *int
+	Hidden  *int
+	Skipped *int
+}
+
+func TestDeepCopyInteriorPointersToSkippedFields(t *testing.T) {
+	h := &hiddenFieldT{Pub: 1, hidden: 2, Skipped: 3}
+	sample := pointersToHiddenT{
+		Struct:  h,
+		Pub:     &h.Pub,
+		Hidden:  &h.hidden,
+		Skipped: &h.Skipped,
+	}
+
+	for name, opts := range map[string][]Option{
+		"plan": nil,
+		"visitor": {OptionWithVisitorFunc(func(_ *ProcContext, v reflect.Value, _ *reflect.StructField) (reflect.Value, bool, error) {
+			return v, true, nil
+		})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := DeepCopy(sample, opts...)
+			require.True(t, result.Pub == &result.Struct.Pub)
+			require.Equal(t, 2, *result.Hidden)
+			require.Equal(t, 3, *result.Skipped)
+			require.False(t, result.Hidden == &result.Struct.hidden)
+			require.False(t, result.Skipped == &result.Struct.Skipped)
+
+			result = DeepCopy(sample, append(opts, OptionWithUnexported(true))...)
+			require.True(t, result.Hidden == &result.Struct.hidden)
+			require.Equal(t, 2, *result.Hidden)
+		})
+	}
+}
+
+type sliceAliasingT struct {
+	View    []int
+	Limited []int
+	Buf     []int
+	Ptr     *int
+	Bytes   [][]byte
+}
+
+func TestDeepCopyPreserveSliceAliasing(t *testing.T) {
+	buf := make([]int, 4, 8)
+	for i := range buf {
+		buf[i] = i
+	}
+	bytes := []byte("hello world")
+	sample := sliceAliasingT{
+		View:    buf[1:3],
+		Limited: buf[2:3:4],
+		Buf:     buf,
+		Ptr:     &buf[3],
+		Bytes:   [][]byte{bytes[:5], bytes[6:]},
+	}
+
+	check := func(t *testing.T, result sliceAliasingT) {
+		require.Equal(t, sample, result)
+		require.False(t, &result.Buf[0] == &sample.Buf[0])
+		require.Equal(t, cap(sample.Buf), cap(result.Buf))
+		require.Equal(t, cap(sample.View), cap(result.View))
+		require.Equal(t, cap(sample.Limited), cap(result.Limited))
+		require.True(t, &result.View[0] == &result.Buf[1])
+		require.True(t, &result.Limited[0] == &result.Buf[2])
+		require.True(t, result.Ptr == &result.Buf[3])
+		require.Equal(t, []byte("hello world"), result.Bytes[0][:cap(result.Bytes[0])])
+		require.True(t, &result.Bytes[0][:cap(result.Bytes[0])][6] == &result.Bytes[1][0])
+	}
+
+	t.Run("plan", func(t *testing.T) {
+		check(t, DeepCopy(sample, OptionPreserveSliceAliasing(true)))
+	})
+	t.Run("visitor", func(t *testing.T) {
+		check(t, DeepCopy(sample, OptionPreserveSliceAliasing(true), OptionWithVisitorFunc(func(_ *ProcContext, v reflect.Value, _ *reflect.StructField) (reflect.Value, bool, error) {
+			return v, true, nil
+		})))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		result := DeepCopy(sample)
+		require.Equal(t, sample, result)
+		require.False(t, &result.View[0] == &result.Buf[1])
+	})
+}
+
+type pointerKeyT struct {
+	Name   *string
+	Secret string `secret:""`
+}
+
+func TestDeepCopyMapKeys(t *testing.T) {
+	name := "name"
+	sample := map[pointerKeyT]int{
+		{Name: &name, Secret: "a"}: 1,
+	}
+
+	t.Run("plan", func(t *testing.T) {
+		result := DeepCopy(sample)
+		require.Len(t, result, 1)
+		for k, v := range result {
+			require.Equal(t, 1, v)
+			require.Equal(t, "name", *k.Name)
+			require.Equal(t, "a", k.Secret)
+			require.False(t, k.Name == &name)
+		}
+	})
+
+	t.Run("visitor", func(t *testing.T) {
+		var keyPaths []string
+		result := DeepCopy(sample, OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			if ctx.IsMapKey() {
+				keyPaths = append(keyPaths, ctx.Path())
+			}
+			if v.Kind() == reflect.String {
+				// all the strings of the sample are inside the key
+				require.True(t, ctx.InMapKey())
+			}
+			return v, true, nil
+		}))
+		require.Len(t, keyPaths, 1)
+		require.Contains(t, keyPaths[0], "(key)")
+		for k := range result {
+			require.Equal(t, "name", *k.Name)
+			require.False(t, k.Name == &name)
+		}
+	})
+
+	t.Run("without-secrets", func(t *testing.T) {
+		result := DeepCopyWithoutSecrets(sample)
+		require.Len(t, result, 1)
+		for k := range result {
+			require.Equal(t, "name", *k.Name)
+			require.Empty(t, k.Secret)
+		}
+	})
+
+	t.Run("unexported", func(t *testing.T) {
+		sample := map[time.Time]int{
+			time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC): 1,
+			time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC): 2,
+		}
+		require.Equal(t, sample, DeepCopy(sample))
+		require.Equal(t, sample, DeepCopyWithoutSecrets(sample))
+	})
+}
+
+func TestDeepCopyMapKeyCollision(t *testing.T) {
+	sample := map[mapKey]int{
+		{1, 2}: 1,
+		{1, 3}: 2,
+		{2, 2}: 3,
+	}
+
+	_, err := TryDeepCopyWithoutSecrets(sample)
+	require.ErrorIs(t, err, ErrMapKeyCollision)
+	var pathErr *PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Contains(t, pathErr.Path, "(key)")
+
+	result := DeepCopyWithoutSecrets(sample, OptionMapKeyCollision(MapKeyCollisionKeepAny))
+	require.Len(t, result, 2)
+	require.Contains(t, []int{1, 2}, result[mapKey{1, 0}])
+	require.Equal(t, 3, result[mapKey{2, 0}])
+
+	result = DeepCopyWithoutSecrets(sample, OptionMapKeyCollision(MapKeyCollisionDrop))
+	require.Equal(t, map[mapKey]int{{2, 0}: 3}, result)
+}
+
+type fieldPolicyT struct {
+	Shared   *innerT `object:"shallow"`
+	Skipped  *innerT `object:"-"`
+	Copied   *innerT
+	internal *innerT `object:"copy=unexported"`
+	ignored  int
+}
+
+type invalidFieldPolicyT struct {
+	Field int `object:"deep"`
+}
+
+func TestDeepCopyFieldPolicy(t *testing.T) {
+	sample := fieldPolicyT{
+		Shared:   &innerT{V: 1},
+		Skipped:  &innerT{V: 2},
+		Copied:   &innerT{V: 3},
+		internal: &innerT{V: 4},
+		ignored:  5,
+	}
+
+	check := func(t *testing.T, result fieldPolicyT) {
+		require.True(t, result.Shared == sample.Shared)
+		require.Nil(t, result.Skipped)
+		require.Equal(t, sample.Copied, result.Copied)
+		require.False(t, result.Copied == sample.Copied)
+		require.Equal(t, sample.internal, result.internal)
+		require.False(t, result.internal == sample.internal)
+		require.Zero(t, result.ignored)
+	}
+
+	t.Run("plan", func(t *testing.T) {
+		check(t, DeepCopy(sample))
+	})
+	t.Run("visitor", func(t *testing.T) {
+		check(t, DeepCopy(sample, OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			return v, true, nil
+		})))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := TryDeepCopy(invalidFieldPolicyT{})
+		var pathErr *PathError
+		require.ErrorAs(t, err, &pathErr)
+		require.Equal(t, ".*.Field", pathErr.Path)
+
+		_, err = TryDeepCopyWithoutSecrets(invalidFieldPolicyT{})
+		require.ErrorAs(t, err, &pathErr)
+		require.Equal(t, ".*.Field", pathErr.Path)
+	})
+}
@@ -0,0 +1,76 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrMapKeyCollision is returned if two different keys of a map become
+// equal after processing, see MapKeyCollisionPolicy.
+var ErrMapKeyCollision = errors.New("map key collision: two different keys became equal")
+
+// ErrIntOverflow is returned if a value of type int, uint or uintptr
+// does not fit into 32 bits, see IntOverflowPolicy.
+var ErrIntOverflow = errors.New("the value does not fit into 32 bits (the size of int, uint and uintptr on 32-bit platforms)")
+
+// ErrEmptyKey is returned if the key of a keyed hash is empty,
+// see NewHashBuilderKeyed.
+var ErrEmptyKey = errors.New("the key of a keyed hash is empty")
+
+// PathError is an error which happened while processing a specific node
+// of an object.
+type PathError struct {
+	// Path is the path to the node, see ProcContext.Path.
+	Path string
+
+	// Type is the type of the node.
+	Type reflect.Type
+
+	// Err is the cause.
+	Err error
+}
+
+var _ error = (*PathError)(nil)
+
+func newPathError(ctx *ProcContext, t reflect.Type, err error) *PathError {
+	return &PathError{
+		Path: ctx.path,
+		Type: t,
+		Err:  err,
+	}
+}
+
+// withPathPrefix prepends `pathPart` to the path of `err` (if it is a *PathError).
+//
+// It is used by copy plans: they do not maintain ProcContext-s (to be fast),
+// so the path is restored only on an error.
+func withPathPrefix(err error, pathPart string) error {
+	if pathErr, ok := err.(*PathError); ok {
+		pathErr.Path = "." + pathPart + pathErr.Path
+	}
+	return err
+}
+
+// Error implements interface error.
+func (err *PathError) Error() string {
+	return fmt.Sprintf("at '%s' (type %v): %v", err.Path, err.Type, err.Err)
+}
+
+// Unwrap returns the cause.
+func (err *PathError) Unwrap() error {
+	return err.Err
+}
+
+// setValue is the same as `dst.Set(v)`, but returns an error instead of
+// panicking if the value `v` is not assignable to `dst`.
+func setValue(ctx *ProcContext, dst reflect.Value, v reflect.Value) error {
+	if !v.IsValid() {
+		return newPathError(ctx, dst.Type(), fmt.Errorf("received an invalid value"))
+	}
+	if !v.Type().AssignableTo(dst.Type()) {
+		return newPathError(ctx, dst.Type(), fmt.Errorf("received a value of a wrong type: expected:%s, received:%s", dst.Type(), v.Type()))
+	}
+	dst.Set(v)
+	return nil
+}
@@ -0,0 +1,74 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldPolicy is the copy behavior of a struct field,
+// defined by tag `object:"..."`:
+//
+//   - `object:"-"` leaves the field zero;
+//   - `object:"shallow"` copies the field as is (for example, the copy shares
+//     the map, the slice or the value behind the pointer with the original);
+//   - `object:"copy=unexported"` copies the unexported field even
+//     without OptionWithUnexported.
+//
+// Options could be combined using a comma, e.g. `object:"shallow,copy=unexported"`.
+type fieldPolicy struct {
+	Skip           bool
+	Shallow        bool
+	CopyUnexported bool
+
+	// Err is the error of parsing the tag (if any).
+	Err error
+}
+
+func parseFieldPolicy(tag reflect.StructTag) fieldPolicy {
+	value, ok := tag.Lookup("object")
+	if !ok {
+		return fieldPolicy{}
+	}
+	if value == "-" {
+		return fieldPolicy{Skip: true}
+	}
+	var policy fieldPolicy
+	for _, opt := range strings.Split(value, ",") {
+		switch opt {
+		case "":
+		case "shallow":
+			policy.Shallow = true
+		case "copy=unexported":
+			policy.CopyUnexported = true
+		default:
+			policy.Err = fmt.Errorf("unknown option '%s' in tag `object:\"%s\"`", opt, value)
+		}
+	}
+	return policy
+}
+
+var fieldPolicies sync.Map // reflect.Type -> []fieldPolicy
+
+// getFieldPolicies returns the policies of all the fields of struct type `t`.
+func getFieldPolicies(t reflect.Type) []fieldPolicy {
+	if policies, ok := fieldPolicies.Load(t); ok {
+		return policies.([]fieldPolicy)
+	}
+	policies := make([]fieldPolicy, t.NumField())
+	for i := range policies {
+		policies[i] = parseFieldPolicy(t.Field(i).Tag)
+	}
+	fieldPolicies.Store(t, policies)
+	return policies
+}
+
+// isCopied returns true if the field should be copied (either deeply or
+// shallowly), given the field is exported or not, and the configuration.
+func (policy *fieldPolicy) isCopied(exported bool, processUnexported bool) bool {
+	if policy.Skip {
+		return false
+	}
+	return exported || processUnexported || policy.CopyUnexported
+}
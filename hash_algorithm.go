@@ -0,0 +1,112 @@
+package object
+
+import (
+	"encoding"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"hash/maphash"
+	"reflect"
+)
+
+// HashAlgorithm is the hash function, which is fed with the encoded values
+// by HashBuilder (see OptionHashAlgorithm).
+type HashAlgorithm int
+
+const (
+	// HashAlgorithmUndefined is the zero value, it means HashAlgorithmSecure.
+	HashAlgorithmUndefined = HashAlgorithm(iota)
+
+	// HashAlgorithmSecure is the cryptographically secure hash used
+	// by CalcCryptoHash: BLAKE3-512 concatenated with SHA-512 (128 bytes).
+	HashAlgorithmSecure
+
+	// HashAlgorithmFNV64a is 64-bit FNV-1a: fast, but not collision
+	// resistant. It does not change between runs of the program.
+	HashAlgorithmFNV64a
+
+	// HashAlgorithmMapHash is 64-bit hash/maphash: the fastest one, but
+	// not collision resistant. It is seeded randomly on every start of
+	// the program, so the hashes are suitable only for in-memory use
+	// (for example, for sharding of maps) even if the hashing is stable.
+	HashAlgorithmMapHash
+
+	endOfHashAlgorithm
+)
+
+// String implements fmt.Stringer.
+func (alg HashAlgorithm) String() string {
+	switch alg {
+	case HashAlgorithmUndefined:
+		return "undefined"
+	case HashAlgorithmSecure:
+		return "secure"
+	case HashAlgorithmFNV64a:
+		return "fnv64a"
+	case HashAlgorithmMapHash:
+		return "maphash"
+	default:
+		return fmt.Sprintf("unknown_algorithm_%d", int(alg))
+	}
+}
+
+var mapHashSeed = maphash.MakeSeed()
+
+// newHash returns a new instance of the hash function,
+// or nil if the algorithm is unknown.
+func (alg HashAlgorithm) newHash() hash.Hash {
+	switch alg {
+	case HashAlgorithmUndefined, HashAlgorithmSecure:
+		return newSecureHash()
+	case HashAlgorithmFNV64a:
+		return fnv.New64a()
+	case HashAlgorithmMapHash:
+		h := &maphash.Hash{}
+		h.SetSeed(mapHashSeed)
+		return h
+	default:
+		return nil
+	}
+}
+
+// newHashFuncLike returns a function, which creates new (reset) instances
+// of the same hash function as `h`, or nil if it is unknown how to do
+// that. Besides the hashes of this package it supports the hashes
+// implementing encoding.BinaryMarshaler (for example, all the hashes
+// of the standard library).
+func newHashFuncLike(h hash.Hash) func() hash.Hash {
+	switch h := h.(type) {
+	case nil:
+		return nil
+	case *secureHash:
+		return h.newEmpty
+	}
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil
+	}
+	t := reflect.TypeOf(h)
+	if t.Kind() != reflect.Pointer {
+		return nil
+	}
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	newHash := func() hash.Hash {
+		newH, ok := reflect.New(t.Elem()).Interface().(hash.Hash)
+		if !ok {
+			return nil
+		}
+		unmarshaler, ok := newH.(encoding.BinaryUnmarshaler)
+		if !ok || unmarshaler.UnmarshalBinary(state) != nil {
+			return nil
+		}
+		newH.Reset()
+		return newH
+	}
+	if newHash() == nil {
+		return nil
+	}
+	return newHash
+}
@@ -6,7 +6,6 @@ import (
 	"hash"
 	"math"
 	"reflect"
-	"sort"
 	"sync"
 
 	"github.com/xaionaro-go/unsafetools"
@@ -19,33 +18,119 @@ type HashBuilder struct {
 	HashValue     hash.Hash
 	StableHashing bool
 	byteOrder     binary.ByteOrder
+	config        config
+
+	// pointerIDs are the indexes of the already written pointers
+	// (see OptionHashPointerSharing).
+	pointerIDs map[pointerKey]uint64
+
+	// newHash creates new instances of the hash function of HashValue
+	// (see writeUnordered); if nil, then OptionHashAlgorithm is used.
+	newHash func() hash.Hash
+
+	// err is the error of the construction of the builder
+	// (see checkHashValue).
+	err error
 }
 
 // NewBuilderUnstable returns a new instance of HashBuilder that
 // builds unstable hashes (that change for the same object
 // after each restart of the program).
-func NewHashBuilderUnstable(hash hash.Hash) *HashBuilder {
+//
+// By default unexported fields are not hashed in HashFormatV1 and are
+// hashed in HashFormatV2 (see OptionWithUnexported). Tags `hash:"..."`
+// are handled the same way as by NewHashBuilderStable.
+//
+// If `hash` is nil, then it is defined by OptionHashAlgorithm (if the
+// algorithm is unknown, then the hashing methods return an error).
+func NewHashBuilderUnstable(hash hash.Hash, opts ...Option) *HashBuilder {
+	cfg := Options(opts).hashConfig()
+	newHash := newHashFuncLike(hash)
+	if hash == nil {
+		newHash = cfg.HashAlgorithm.newHash
+		hash = newHash()
+	}
 	return &HashBuilder{
 		HashValue:     hash,
 		StableHashing: false,
 		byteOrder:     binary.NativeEndian,
+		config:        cfg,
+		newHash:       newHash,
 	}
 }
 
 // NewBuilderStable returns a new instance of HashBuilder that
 // builds stable hashes (that do not change for the same object
 // after each restart of the program).
-func NewHashBuilderStable(hash hash.Hash) *HashBuilder {
+//
+// By default the legacy HashFormatV1 is used (see OptionHashFormat).
+// Unexported fields are not hashed in HashFormatV1 and are hashed
+// in HashFormatV2 by default (see OptionWithUnexported).
+//
+// Struct fields tagged `hash:"-"` are not hashed; fields tagged
+// `hash:"name=foo"` are identified in the hash by name "foo"
+// (instead of the position or the Go name of the field); slices and
+// arrays tagged `hash:"unordered"` are hashed independently of the order
+// of their elements. Values implementing ObjectHasher are hashed by
+// themselves.
+//
+// If `hash` is nil, then it is defined by OptionHashAlgorithm (if the
+// algorithm is unknown, then the hashing methods return an error). The entries
+// of maps and the elements of unordered slices are hashed separately
+// (see HashFormatV2) by new instances of the same hash function (if it
+// implements encoding.BinaryMarshaler, like the hashes of the standard
+// library do), or of OptionHashAlgorithm otherwise.
+func NewHashBuilderStable(hash hash.Hash, opts ...Option) *HashBuilder {
+	cfg := Options(opts).hashConfig()
+	newHash := newHashFuncLike(hash)
+	if hash == nil {
+		newHash = cfg.HashAlgorithm.newHash
+		hash = newHash()
+	}
 	return &HashBuilder{
 		HashValue:     hash,
 		StableHashing: true,
 		byteOrder:     binary.LittleEndian,
+		config:        cfg,
+		newHash:       newHash,
+	}
+}
+
+// NewHashBuilderKeyed returns a new instance of HashBuilder that
+// builds stable (see NewHashBuilderStable) cryptographically secure hashes
+// keyed by `key`: keyed BLAKE3 and HMAC-SHA-512. Without the key the hashes
+// could not be reproduced (for example, to brute-force the values),
+// so they could be compared only by the holders of the key.
+//
+// Unlike NewHashBuilderStable, by default it uses HashFormatV2.
+//
+// The key must not be empty, otherwise the hashing methods return
+// ErrEmptyKey.
+func NewHashBuilderKeyed(key []byte, opts ...Option) *HashBuilder {
+	opts = append([]Option{OptionHashFormat(HashFormatV2)}, opts...)
+	h, err := newKeyedSecureHash(key)
+	if err != nil {
+		return &HashBuilder{
+			StableHashing: true,
+			byteOrder:     binary.LittleEndian,
+			config:        Options(opts).hashConfig(),
+			err:           err,
+		}
 	}
+	return NewHashBuilderStable(h, opts...)
 }
 
 func (b *HashBuilder) extend(in []byte) error {
 	h := b.HashValue
 
+	if b.hashFormat() != HashFormatV1 {
+		_, err := h.Write(in)
+		if err != nil {
+			return fmt.Errorf("unable to extend %T: %w", h, err)
+		}
+		return nil
+	}
+
 	oldHash := h.Sum(nil)
 
 	_, err := h.Write(oldHash)
@@ -68,43 +153,176 @@ func (b *HashBuilder) Write(args ...any) error {
 	return b.write(args...)
 }
 
-func (b *HashBuilder) write(args ...any) error {
-	var writeType func(t reflect.Type) error
+func (b *HashBuilder) writeTypeFunc() func(t reflect.Type) error {
 	if b.StableHashing {
-		writeType = func(t reflect.Type) error {
-			return b.writeString(t.PkgPath(), ".", t.Name())
+		if b.hashFormat() == HashFormatV1 {
+			// the legacy marker, it is ambiguous for unnamed types
+			// (for example, []int and map[string]int)
+			return func(t reflect.Type) error {
+				return b.writeString(t.PkgPath(), ".", t.Name())
+			}
 		}
-	} else {
-		writeType = func(t reflect.Type) error {
-			typePtr := reflect.ValueOf(t).Pointer()
-			return b.writeUintptr(typePtr)
+		return func(t reflect.Type) error {
+			return b.writeString(typeDescriptor(t))
 		}
 	}
+	return func(t reflect.Type) error {
+		typePtr := reflect.ValueOf(t).Pointer()
+		return b.writeUintptr(typePtr)
+	}
+}
 
+func (b *HashBuilder) write(args ...any) error {
 	for idx, obj := range args {
-		err := Traverse(
-			obj,
-			func(
-				ctx *ProcContext,
-				v reflect.Value,
-				sf *reflect.StructField,
-			) (reflect.Value, bool, error) {
-				t := v.Type()
+		err := b.writeReflectValue(reflect.ValueOf(obj))
+		if err != nil {
+			return fmt.Errorf("unable to traverse&hash argument #%d of type %T: %w", idx, obj, err)
+		}
+	}
+	return nil
+}
+
+// hashFormat returns the effective format of the encoding.
+func (b *HashBuilder) hashFormat() HashFormat {
+	if b.config.HashFormat == HashFormatUndefined {
+		return HashFormatV1
+	}
+	return b.config.HashFormat
+}
+
+func (b *HashBuilder) writeReflectValue(v reflect.Value) error {
+	if format := b.hashFormat(); format >= endOfHashFormat || format < HashFormatUndefined {
+		return fmt.Errorf("unknown hash format: %s", format)
+	}
+	if err := b.checkHashValue(); err != nil {
+		return err
+	}
+	if b.config.HashPointerSharing && b.pointerIDs == nil {
+		b.pointerIDs = map[pointerKey]uint64{}
+		defer func() { b.pointerIDs = nil }()
+	}
+	if !v.IsValid() {
+		// an untyped nil
+		return b.writeBool(true)
+	}
+	writeType := b.writeTypeFunc()
+	// In HashFormatV1 the type is written for every node. In the newer
+	// formats it is written only if it is not implied by the parent node
+	// (that is for the root and for the values inside interfaces).
+	var lastInterfaceCtx *ProcContext
+	traverser := newTraverser(b.config)
+	traverser.PointersByAddress = b.hashFormat() == HashFormatV1
+	_, err := traverser.traverse(
+		v,
+		func(
+			ctx *ProcContext,
+			v reflect.Value,
+			sf *reflect.StructField,
+		) (reflect.Value, bool, error) {
+			unordered := false
+			if sf != nil {
+				policy := getHashFieldPolicy(sf.Tag)
+				if policy.Err != nil {
+					return v, false, fmt.Errorf("field '%s': %w", sf.Name, policy.Err)
+				}
+				if policy.Skip {
+					return v, false, nil
+				}
+				unordered = policy.Unordered
+				switch {
+				case policy.Name != "":
+					if err := b.writeString(policy.Name); err != nil {
+						return v, false, fmt.Errorf("unable to extend the name '%s' of field '%s': %w", policy.Name, sf.Name, err)
+					}
+				case b.config.ProcessUnexported:
+					// otherwise an exported and an unexported fields could be confused
+					if err := b.writeString(sf.PkgPath, ".", sf.Name); err != nil {
+						return v, false, fmt.Errorf("unable to extend the name of field '%s': %w", sf.Name, err)
+					}
+				}
+			}
+			t := v.Type()
+			isTypeImplied := ctx.Parent() != nil && ctx.Parent() != lastInterfaceCtx
+			if t.Kind() == reflect.Interface {
+				// the next node (if any) is the value inside the interface
+				lastInterfaceCtx = ctx
+			}
+			if !isTypeImplied || b.hashFormat() == HashFormatV1 {
 				if err := writeType(t); err != nil {
 					return v, false, fmt.Errorf("unable to extend the type '%s' pointer of the object: %w", t, err)
 				}
-				shouldContinue, err := b.writeValue(v)
-				if err != nil {
-					return v, false, fmt.Errorf("unable to extend the value of type '%s': %w", t, err)
+			}
+			if hashFunc := b.customHashFunc(v); hashFunc != nil {
+				if err := hashFunc(b.nested()); err != nil {
+					return v, false, fmt.Errorf("unable to hash the value of type '%s': %w", t, err)
 				}
-				return v, shouldContinue, nil
-			},
-		)
-		if err != nil {
-			return fmt.Errorf("unable to traverse&hash argument #%d of type %T: %w", idx, obj, err)
+				return v, false, nil
+			}
+			if unordered {
+				if err := b.writeSliceUnordered(v); err != nil {
+					return v, false, fmt.Errorf("unable to extend the value of field '%s': %w", sf.Name, err)
+				}
+				return v, false, nil
+			}
+			shouldContinue, err := b.writeValue(v)
+			if err != nil {
+				return v, false, fmt.Errorf("unable to extend the value of type '%s': %w", t, err)
+			}
+			return v, shouldContinue, nil
+		},
+		newProcContext(),
+		nil,
+	)
+	return err
+}
+
+// ObjectHasher is implemented by types, which define how they are
+// hashed (for example, if the canonical form of a value differs
+// from its memory layout). The type of the value is still written
+// into the hash by the HashBuilder.
+type ObjectHasher interface {
+	// WriteObjectHash writes the canonical form of the value
+	// into the HashBuilder (using its method Write).
+	WriteObjectHash(*HashBuilder) error
+}
+
+var objectHasherType = reflect.TypeOf((*ObjectHasher)(nil)).Elem()
+
+// asInterface returns `v` (or its address) as interface `ifaceT`,
+// if it implements the interface.
+func asInterface(v reflect.Value, ifaceT reflect.Type) (any, bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		// the dynamic value will be checked instead
+		return nil, false
+	case reflect.Pointer:
+		if v.IsNil() {
+			// calling a method may panic
+			return nil, false
 		}
 	}
-	return nil
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if v.Type().Implements(ifaceT) {
+		return v.Interface(), true
+	}
+	if v.CanAddr() && reflect.PointerTo(v.Type()).Implements(ifaceT) {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+// nested returns a HashBuilder which writes into the same hash,
+// to be provided to ObjectHasher (`b` is locked at this moment).
+func (b *HashBuilder) nested() *HashBuilder {
+	return &HashBuilder{
+		HashValue:     b.HashValue,
+		StableHashing: b.StableHashing,
+		byteOrder:     b.byteOrder,
+		config:        b.config,
+		newHash:       b.newHash,
+	}
 }
 
 func (b *HashBuilder) getBuffer(size uint) []byte {
@@ -114,15 +332,26 @@ func (b *HashBuilder) getBuffer(size uint) []byte {
 func (b *HashBuilder) writeString(ss ...string) error {
 	h := b.HashValue
 
-	oldHash := h.Sum(nil)
-
-	_, err := h.Write(oldHash)
-	if err != nil {
-		return fmt.Errorf("unable to write old hash: %w", err)
+	if b.hashFormat() == HashFormatV1 {
+		oldHash := h.Sum(nil)
+		_, err := h.Write(oldHash)
+		if err != nil {
+			return fmt.Errorf("unable to write old hash: %w", err)
+		}
+	} else {
+		length := 0
+		for _, s := range ss {
+			length += len(s)
+		}
+		b.byteOrder.PutUint64(b.buffer[:], uint64(length))
+		_, err := h.Write(b.buffer[:8])
+		if err != nil {
+			return fmt.Errorf("unable to write the length of a string: %w", err)
+		}
 	}
 
 	for _, s := range ss {
-		_, err = h.Write(unsafetools.CastStringToBytes(s))
+		_, err := h.Write(unsafetools.CastStringToBytes(s))
 		if err != nil {
 			return fmt.Errorf("unable to extend string '%s': %w", s, err)
 		}
@@ -136,7 +365,7 @@ func (b *HashBuilder) writeValue(v reflect.Value) (bool, error) {
 	case reflect.Bool:
 		return false, b.writeBool(v.Bool())
 	case reflect.Int:
-		return false, b.writeUint(uint(v.Int()))
+		return false, b.writePlatformInt(v.Int())
 	case reflect.Int8:
 		return false, b.writeUint8(uint8(v.Int()))
 	case reflect.Int16:
@@ -146,7 +375,7 @@ func (b *HashBuilder) writeValue(v reflect.Value) (bool, error) {
 	case reflect.Int64:
 		return false, b.writeUint64(uint64(v.Int()))
 	case reflect.Uint:
-		return false, b.writeUint(uint(v.Uint()))
+		return false, b.writePlatformUint(v.Uint(), v.Kind())
 	case reflect.Uint8:
 		return false, b.writeUint8(uint8(v.Uint()))
 	case reflect.Uint16:
@@ -156,7 +385,7 @@ func (b *HashBuilder) writeValue(v reflect.Value) (bool, error) {
 	case reflect.Uint64:
 		return false, b.writeUint64(uint64(v.Uint()))
 	case reflect.Uintptr:
-		return false, b.writeUintptr(uintptr(v.Uint()))
+		return false, b.writePlatformUint(v.Uint(), v.Kind())
 	case reflect.Float32:
 		return false, b.writeFloat32(float32(v.Float()))
 	case reflect.Float64:
@@ -166,22 +395,69 @@ func (b *HashBuilder) writeValue(v reflect.Value) (bool, error) {
 	case reflect.Complex128:
 		return false, b.writeComplex128(v.Complex())
 	case reflect.Array:
+		if err := b.writeLength(v.Len()); err != nil {
+			return false, err
+		}
+		if raw, ok := b.rawPrimitives(v); ok {
+			return false, b.extend(raw)
+		}
 		// the items of the array will be traversed by Traverse, we need to write the length only here
-		return true, b.writeUint(uint(v.Len()))
+		return true, nil
 	case reflect.Chan:
 		return false, fmt.Errorf("unable to serialize a channel")
 	case reflect.Func:
 		return false, fmt.Errorf("unable to serialize a function")
 	case reflect.Interface:
-		return false, nil
+		if b.hashFormat() == HashFormatV1 {
+			// the legacy format hashes only the static type
+			return false, nil
+		}
+		// the dynamic type and the value will be traversed by Traverse,
+		// we need to distinguish a nil interface from a typed nil here only
+		if v.IsNil() {
+			return false, b.writeBool(true)
+		}
+		// errors are hashed by their contents (like any other values),
+		// their messages may be not stable
+		return true, b.writeBool(false)
 	case reflect.Map:
-		return false, b.writeMapRF(v)
+		if b.config.HashExplicitNil {
+			if err := b.writeBool(v.IsNil()); err != nil {
+				return false, err
+			}
+		}
+		if b.hashFormat() == HashFormatV1 {
+			return false, b.writeMapV1(v)
+		}
+		if err := b.writeLength(v.Len()); err != nil {
+			return false, err
+		}
+		return false, b.writeMapUnordered(v)
 	case reflect.Pointer:
+		if b.hashFormat() != HashFormatV1 || b.config.HashExplicitNil || b.config.HashPointerSharing {
+			if err := b.writeBool(v.IsNil()); err != nil {
+				return false, err
+			}
+		}
+		if b.config.HashPointerSharing && !v.IsNil() {
+			return b.writePointerReference(v)
+		}
 		// asking to traverse it:
 		return true, nil
 	case reflect.Slice:
+		if b.config.HashExplicitNil {
+			if err := b.writeBool(v.IsNil()); err != nil {
+				return false, err
+			}
+		}
+		if err := b.writeLength(v.Len()); err != nil {
+			return false, err
+		}
+		if raw, ok := b.rawPrimitives(v); ok {
+			return false, b.extend(raw)
+		}
 		// the items of the slice will be traversed by Traverse, we need to write the length only here
-		return true, b.writeUint(uint(v.Len()))
+		return true, nil
 	case reflect.String:
 		return false, b.writeString(v.String())
 	case reflect.Struct:
@@ -193,6 +469,31 @@ func (b *HashBuilder) writeValue(v reflect.Value) (bool, error) {
 	}
 }
 
+// writePointerReference writes 0 if the value behind non-nil pointer `v`
+// is met for the first time (and so it should be traversed), or the
+// 1-based index of the previously written pointer to the same value
+// (a back-reference), see OptionHashPointerSharing.
+func (b *HashBuilder) writePointerReference(v reflect.Value) (bool, error) {
+	if !isTrackablePointer(v) {
+		// a value of zero size, it has no identity
+		return true, b.writeUint64(0)
+	}
+	key := newPointerKey(v)
+	if id, ok := b.pointerIDs[key]; ok {
+		return false, b.writeUint64(id + 1)
+	}
+	b.pointerIDs[key] = uint64(len(b.pointerIDs))
+	return true, b.writeUint64(0)
+}
+
+// writeLength writes the length of a slice, an array or a map.
+func (b *HashBuilder) writeLength(length int) error {
+	if b.hashFormat() == HashFormatV1 {
+		return b.writeUint(uint(length))
+	}
+	return b.writeUint64(uint64(length))
+}
+
 func (b *HashBuilder) writeUintptr(v uintptr) error {
 	size := uintptrSize
 	buf := b.getBuffer(size)
@@ -214,6 +515,9 @@ func (b *HashBuilder) Result() []byte {
 	return b.result()
 }
 func (b *HashBuilder) result() []byte {
+	if b.HashValue == nil {
+		return nil
+	}
 	return b.HashValue.Sum(nil)
 }
 
@@ -225,9 +529,26 @@ func (b *HashBuilder) Reset() {
 }
 
 func (b *HashBuilder) reset() {
+	if b.HashValue == nil {
+		// see checkHashValue
+		return
+	}
 	b.HashValue.Reset()
 }
 
+// checkHashValue returns an error if there is no hash function (if
+// the builder is created with an unknown OptionHashAlgorithm or
+// an empty key).
+func (b *HashBuilder) checkHashValue() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.HashValue == nil {
+		return fmt.Errorf("unknown hash algorithm: %s", b.config.HashAlgorithm)
+	}
+	return nil
+}
+
 // ResetAndHash resets the state of the hash, calculates a new hash
 // using given arguments, and returns it's value.
 func (b *HashBuilder) ResetAndHash(args ...any) (Hash, error) {
@@ -237,10 +558,25 @@ func (b *HashBuilder) ResetAndHash(args ...any) (Hash, error) {
 }
 
 func (b *HashBuilder) resetAndHash(args ...any) (Hash, error) {
+	if err := b.checkHashValue(); err != nil {
+		return nil, err
+	}
 	b.reset()
 	err := b.write(args...)
 	if err != nil {
-		return nil, fmt.Errorf("unable to write the values to ")
+		return nil, fmt.Errorf("unable to write the values to the hash: %w", err)
+	}
+	return b.result(), nil
+}
+
+func (b *HashBuilder) resetAndHashReflectValue(v reflect.Value) (Hash, error) {
+	if err := b.checkHashValue(); err != nil {
+		return nil, err
+	}
+	b.reset()
+	err := b.writeReflectValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write the value to the hash: %w", err)
 	}
 	return b.result(), nil
 }
@@ -252,7 +588,54 @@ func (b *HashBuilder) writeBool(v bool) error {
 	}
 	return b.writeUint8(u)
 }
+
+// writePlatformInt writes a value of type int. Its size depends on
+// the platform, so in the stable mode it is always written as 64-bit.
+func (b *HashBuilder) writePlatformInt(v int64) error {
+	if !b.StableHashing {
+		return b.writeUint(uint(v))
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		if err := b.checkIntOverflow(); err != nil {
+			return err
+		}
+	}
+	return b.writeUint64(uint64(v))
+}
+
+// writePlatformUint writes a value of type uint or uintptr. Its size
+// depends on the platform, so in the stable mode it is always written
+// as 64-bit.
+func (b *HashBuilder) writePlatformUint(v uint64, kind reflect.Kind) error {
+	if !b.StableHashing {
+		if kind == reflect.Uintptr {
+			return b.writeUintptr(uintptr(v))
+		}
+		return b.writeUint(uint(v))
+	}
+	if v > math.MaxUint32 {
+		if err := b.checkIntOverflow(); err != nil {
+			return err
+		}
+	}
+	return b.writeUint64(v)
+}
+
+func (b *HashBuilder) checkIntOverflow() error {
+	switch b.config.IntOverflowPolicy {
+	case IntOverflowIgnore:
+		return nil
+	case IntOverflowError:
+		return ErrIntOverflow
+	default:
+		return fmt.Errorf("unknown int overflow policy: %d", b.config.IntOverflowPolicy)
+	}
+}
+
 func (b *HashBuilder) writeUint(v uint) error {
+	if b.StableHashing {
+		return b.writeUint64(uint64(v))
+	}
 	size := uintSize
 	buf := b.getBuffer(size)
 	switch size {
@@ -282,74 +665,102 @@ func (b *HashBuilder) writeUint64(v uint64) error {
 	return b.extend(b.buffer[:8])
 }
 func (b *HashBuilder) writeFloat32(v float32) error {
-	b.byteOrder.PutUint32(b.buffer[:], math.Float32bits(v))
+	b.byteOrder.PutUint32(b.buffer[:], math.Float32bits(b.canonicalFloat32(v)))
 	return b.extend(b.buffer[:4])
 }
 func (b *HashBuilder) writeFloat64(v float64) error {
-	b.byteOrder.PutUint64(b.buffer[:], math.Float64bits(v))
+	b.byteOrder.PutUint64(b.buffer[:], math.Float64bits(b.canonicalFloat64(v)))
 	return b.extend(b.buffer[:8])
 }
 func (b *HashBuilder) writeComplex64(v complex64) error {
-	b.byteOrder.PutUint32(b.buffer[0:], math.Float32bits(real(v)))
-	b.byteOrder.PutUint32(b.buffer[4:], math.Float32bits(imag(v)))
+	b.byteOrder.PutUint32(b.buffer[0:], math.Float32bits(b.canonicalFloat32(real(v))))
+	b.byteOrder.PutUint32(b.buffer[4:], math.Float32bits(b.canonicalFloat32(imag(v))))
 	return b.extend(b.buffer[:8])
 }
 func (b *HashBuilder) writeComplex128(v complex128) error {
-	b.byteOrder.PutUint64(b.buffer[0:], math.Float64bits(real(v)))
-	b.byteOrder.PutUint64(b.buffer[8:], math.Float64bits(imag(v)))
+	b.byteOrder.PutUint64(b.buffer[0:], math.Float64bits(b.canonicalFloat64(real(v))))
+	b.byteOrder.PutUint64(b.buffer[8:], math.Float64bits(b.canonicalFloat64(imag(v))))
 	return b.extend(b.buffer[:16])
 }
 
-type valuesAndHashes struct {
-	Values []reflect.Value
-	Hashes []Hash
-}
-
-var _ sort.Interface = (*valuesAndHashes)(nil)
-
-func (s *valuesAndHashes) Len() int {
-	return len(s.Values)
-}
-func (s *valuesAndHashes) Less(i, j int) bool {
-	return s.Hashes[i].Less(s.Hashes[j])
-}
-func (s *valuesAndHashes) Swap(i, j int) {
-	s.Values[i], s.Hashes[i], s.Values[j], s.Hashes[j] = s.Values[j], s.Hashes[j], s.Values[i], s.Hashes[i]
-}
-
-func (b *HashBuilder) writeMapRF(v reflect.Value) error {
-	keys := v.MapKeys()
-	hashes := make([]Hash, len(keys))
-	subBuilder := NewHashBuilderStable(newSecureHash())
-	for idx, key := range keys {
-		var err error
-		hashes[idx], err = subBuilder.resetAndHash(key)
-		if err != nil {
-			return fmt.Errorf("unable to hash map key of type %s: %w", key.Type(), err)
-		}
-	}
-	s := &valuesAndHashes{
-		Values: keys,
-		Hashes: hashes,
-	}
-	sort.Sort(s)
-	for _, key := range s.Values {
-		err := b.write(key)
-		if err != nil {
-			return fmt.Errorf("unable to write map key of type '%s': %w", key.Type(), err)
-		}
-		mapValue := v.MapIndex(key)
-		err = b.write(mapValue)
-		if err != nil {
-			return fmt.Errorf("unable to write map value of type '%s': %w", mapValue.Type(), err)
+// writeMapV1 writes map `v` in HashFormatV1. The legacy implementation
+// (by mistake) hashed every key and value as a value of type
+// reflect.Value (which has no exported fields), so only the number of
+// the entries affects the hash. It is kept as is to do not change
+// the persisted hashes, see HashFormatV2.
+func (b *HashBuilder) writeMapV1(v reflect.Value) error {
+	for i := 0; i < 2*v.Len(); i++ {
+		if err := b.writeString("reflect", ".", "Value"); err != nil {
+			return fmt.Errorf("unable to write a map entry: %w", err)
 		}
 	}
 	return nil
 }
 
-var defaultHashBuilder = NewHashBuilderStable(newSecureHash())
+var (
+	defaultHashBuilder   = NewHashBuilderStable(newSecureHash())
+	defaultHashBuilderV2 = NewHashBuilderStable(newSecureHash(), OptionHashFormat(HashFormatV2))
+)
 
 // CalcCryptoHash returns a cryptographically secure hash of an arbitrary set of values.
+//
+// It uses the legacy HashFormatV1 to do not change the already persisted
+// hashes, so for example maps with the same number of entries and
+// interfaces with the same static type have the same hash (see
+// HashFormatV1). New code should use CalcCryptoHashV2 instead.
 func CalcCryptoHash(args ...any) (Hash, error) {
 	return defaultHashBuilder.ResetAndHash(args...)
 }
+
+// CalcCryptoHashV2 is the same as CalcCryptoHash, but uses HashFormatV2,
+// which hashes the contents of maps and interfaces, describes the types
+// structurally and hashes the standard types (like time.Time) by their
+// canonical representation.
+//
+// The hashes are different from the ones of CalcCryptoHash, so persisted
+// hashes need to be recalculated to migrate from CalcCryptoHash.
+func CalcCryptoHashV2(args ...any) (Hash, error) {
+	return defaultHashBuilderV2.ResetAndHash(args...)
+}
+
+// CalcCryptoHashWithOptions is the same as CalcCryptoHash, but with options
+// (see NewHashBuilderStable). OptionHashAlgorithm is ignored: the hash
+// is always cryptographically secure (see CalcHashWithOptions).
+func CalcCryptoHashWithOptions(opts []Option, args ...any) (Hash, error) {
+	if len(opts) == 0 {
+		return CalcCryptoHash(args...)
+	}
+	return NewHashBuilderStable(newSecureHash(), opts...).ResetAndHash(args...)
+}
+
+// CalcKeyedCryptoHash is the same as CalcCryptoHashV2, but the hash is
+// keyed by `key` (see NewHashBuilderKeyed).
+func CalcKeyedCryptoHash(key []byte, args ...any) (Hash, error) {
+	return NewHashBuilderKeyed(key).ResetAndHash(args...)
+}
+
+// CalcHashWithOptions is the same as CalcCryptoHashWithOptions, but
+// the hash function is defined by OptionHashAlgorithm.
+func CalcHashWithOptions(opts []Option, args ...any) (Hash, error) {
+	return NewHashBuilderStable(nil, opts...).ResetAndHash(args...)
+}
+
+// CalcHash64 returns a fast 64-bit (not cryptographically secure) stable
+// hash of value `v`, which could be used for example as a cache key.
+//
+// By default HashAlgorithmFNV64a and HashFormatV2 are used,
+// see OptionHashAlgorithm and OptionHashFormat.
+func CalcHash64(v any, opts ...Option) (uint64, error) {
+	opts = append([]Option{
+		OptionHashAlgorithm(HashAlgorithmFNV64a),
+		OptionHashFormat(HashFormatV2),
+	}, opts...)
+	b := NewHashBuilderStable(nil, opts...)
+	if err := b.writeReflectValue(reflect.ValueOf(v)); err != nil {
+		return 0, fmt.Errorf("unable to hash the value of type %T: %w", v, err)
+	}
+	if h, ok := b.HashValue.(hash.Hash64); ok {
+		return h.Sum64(), nil
+	}
+	return binary.BigEndian.Uint64(b.result()), nil
+}
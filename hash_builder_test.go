@@ -1,7 +1,20 @@
 package object
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"math/big"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -47,6 +60,40 @@ func TestCalcCryptoHash(t *testing.T) {
 		}
 		require.NotEqual(t, must(CalcCryptoHash(m0)), must(CalcCryptoHash(m1)))
 		require.Equal(t, must(CalcCryptoHash(m0)), must(CalcCryptoHash(m0Dup)))
+
+		m0Dup["e"] = 5
+		require.NotEqual(t, must(CalcCryptoHashV2(m0)), must(CalcCryptoHashV2(m0Dup)))
+		require.NotEqual(t, must(CalcCryptoHashV2(map[string]int{"a": 0})), must(CalcCryptoHashV2(map[string]int{"b": 0})))
+		require.Equal(t, must(CalcCryptoHashV2(m0)), must(CalcCryptoHashV2(maps.Clone(m0))))
+		require.Equal(t,
+			must(CalcCryptoHashV2(m0)),
+			must(CalcCryptoHashWithOptions([]Option{OptionHashFormat(HashFormatV2)}, m0)),
+		)
+	})
+
+	t.Run("unexported", func(t *testing.T) {
+		// HashFormatV2 hashes unexported fields by default
+		require.NotEqual(t, must(CalcCryptoHashV2(s0T{a: 1})), must(CalcCryptoHashV2(s0T{a: 2})))
+		require.NotEqual(t, must(CalcCryptoHashV2(s0T{a: 1})), must(CalcCryptoHashV2(s1T{a: 1})))
+		require.Equal(t, must(CalcCryptoHashV2(s0T{a: 1})), must(CalcCryptoHashV2(s0T{a: 1})))
+		require.NotEqual(t, must(CalcHash64(s0T{a: 1})), must(CalcHash64(s0T{a: 2})))
+		v2Opts := []Option{OptionHashFormat(HashFormatV2), OptionWithUnexported(false)}
+		require.Equal(t,
+			must(CalcCryptoHashWithOptions(v2Opts, s0T{a: 1})),
+			must(CalcCryptoHashWithOptions(v2Opts, s0T{a: 2})),
+		)
+
+		opts := []Option{OptionWithUnexported(true)}
+		require.NotEqual(t, must(CalcCryptoHashWithOptions(opts, s0T{a: 1})), must(CalcCryptoHashWithOptions(opts, s0T{a: 2})))
+		require.Equal(t, must(CalcCryptoHashWithOptions(opts, s0T{a: 1})), must(CalcCryptoHashWithOptions(opts, s0T{a: 1})))
+		require.NotEqual(t, must(CalcCryptoHashWithOptions(opts, s0T{a: 1})), must(CalcCryptoHashWithOptions(opts, s1T{a: 1})))
+
+		b := NewHashBuilderStable(newSecureHash(), opts...)
+		require.NoError(t, b.Write(&s0T{a: 1}))
+		h0 := b.Result()
+		b.Reset()
+		require.NoError(t, b.Write(&s0T{a: 2}))
+		require.NotEqual(t, h0, b.Result())
 	})
 }
 
@@ -56,3 +103,700 @@ func must[T any](in T, err error) T {
 	}
 	return in
 }
+
+type withInterfaceT struct {
+	Value any
+	Err   error
+}
+
+func TestCalcCryptoHashInterface(t *testing.T) {
+	hash := func(v any) Hash {
+		return must(CalcCryptoHashV2(v))
+	}
+
+	require.NotEqual(t, hash(withInterfaceT{Err: errors.New("a")}), hash(withInterfaceT{Err: errors.New("b")}))
+	require.Equal(t, hash(withInterfaceT{Err: errors.New("a")}), hash(withInterfaceT{Err: errors.New("a")}))
+	require.NotEqual(t, hash(withInterfaceT{Value: 1}), hash(withInterfaceT{Value: 2}))
+	require.NotEqual(t, hash(withInterfaceT{Value: int32(1)}), hash(withInterfaceT{Value: int64(1)}))
+	require.NotEqual(t, hash(withInterfaceT{Value: s0T{}}), hash(withInterfaceT{Value: s1T{}}))
+	require.NotEqual(t, hash(withInterfaceT{}), hash(withInterfaceT{Value: (*int)(nil)}))
+	require.NotEqual(t, hash(withInterfaceT{}), hash(withInterfaceT{Err: (*PathError)(nil)}))
+	require.NotEqual(t, hash(map[string]any{"a": 1}), hash(map[string]any{"a": 2}))
+	require.NotEqual(t, hash(nil), hash(withInterfaceT{}))
+	require.Equal(t, hash(nil), hash(nil))
+
+	// errors are hashed by their contents, not by their messages
+	require.Equal(t, hash(withInterfaceT{Err: &testUnstableError{code: 1}}), hash(withInterfaceT{Err: &testUnstableError{code: 1}}))
+	require.NotEqual(t, hash(withInterfaceT{Err: &testUnstableError{code: 1}}), hash(withInterfaceT{Err: &testUnstableError{code: 2}}))
+	require.NotEqual(t, hash(withInterfaceT{Err: errors.New("b: a")}), hash(withInterfaceT{Err: fmt.Errorf("b: %w", errors.New("a"))}))
+
+	// the type of a value after a nil interface is implied as well
+	type sample struct {
+		Value any
+		Next  int32
+	}
+	manual := NewHashBuilderStable(newSecureHash(), OptionHashFormat(HashFormatV2))
+	require.NoError(t, manual.writeString(typeDescriptor(reflect.TypeOf(sample{}))))
+	require.NoError(t, manual.writeString(".Value"))
+	require.NoError(t, manual.writeBool(true))
+	require.NoError(t, manual.writeString(".Next"))
+	require.NoError(t, manual.writeUint32(5))
+	require.Equal(t, Hash(manual.Result()), hash(sample{Next: 5}))
+}
+
+// testUnstableError has a different message every time.
+type testUnstableError struct {
+	code int
+}
+
+var testUnstableErrorCount int
+
+func (err *testUnstableError) Error() string {
+	testUnstableErrorCount++
+	return fmt.Sprintf("error %d (#%d)", err.code, testUnstableErrorCount)
+}
+
+type genericT[T any] struct {
+	Value T
+}
+
+func TestTypeDescriptor(t *testing.T) {
+	for _, tc := range []struct {
+		Value    any
+		Expected string
+	}{
+		{int(0), ".int"},
+		{s0T{}, "github.com/xaionaro-go/object.s0T"},
+		{[]int(nil), "[].int"},
+		{[2]*s0T{}, "[2]*github.com/xaionaro-go/object.s0T"},
+		{map[string][]byte(nil), "map[.string][].uint8"},
+		{(<-chan int)(nil), "<-chan .int"},
+		{(func(int, ...string) error)(nil), "func(.int, ....string) (.error)"},
+		{struct {
+			X int `json:"x"`
+			y string
+		}{}, `struct{X .int "json:\"x\""; "github.com/xaionaro-go/object".y .string}`},
+		{genericT[[]int]{}, "github.com/xaionaro-go/object.genericT[[]int]"},
+	} {
+		require.Equal(t, tc.Expected, typeDescriptor(reflect.TypeOf(tc.Value)))
+	}
+
+	hashes := map[string]any{}
+	for _, v := range []any{
+		[]int(nil),
+		[]uint(nil),
+		map[string]int(nil),
+		(*s0T)(nil),
+		(*s1T)(nil),
+		struct{ X int }{},
+		struct{ Y int }{},
+		struct {
+			X int `json:"x"`
+		}{},
+		genericT[int]{},
+		genericT[uint]{},
+	} {
+		h := must(CalcCryptoHashWithOptions([]Option{OptionHashFormat(HashFormatV2)}, v))
+		require.NotContains(t, hashes, string(h), "%T", v)
+		hashes[string(h)] = v
+	}
+
+	// HashFormatV1 keeps the legacy type markers (the values are
+	// calculated by the version before the descriptors were introduced)
+	x := 5
+	for _, tc := range []struct {
+		Value    any
+		Expected string
+	}{
+		{[]int{1, 2}, "f79e45957cd142b6"},
+		{[]byte{1, 2, 3}, "7b6220ac1b32cbc5"},
+		{&x, "1cbb85bfd1b6efe2"},
+		{(*int)(nil), "14c18fda3c8fc945"},
+		{[3]uint16{1, 2, 3}, "38669dfdf69ffcbd"},
+	} {
+		require.Equal(t, tc.Expected, fmt.Sprintf("%x", must(CalcCryptoHash(tc.Value))[:8]), "%T", tc.Value)
+	}
+}
+
+func TestHashFormat(t *testing.T) {
+	sample := testSample()
+	sample.SomeError = errors.New("some error")
+	v1Opts := []Option{OptionHashFormat(HashFormatV1)}
+	v2Opts := []Option{OptionHashFormat(HashFormatV2)}
+
+	// the legacy format must never change, otherwise persisted hashes
+	// become invalid (the value is calculated by the version before
+	// HashFormat was introduced)
+	require.Equal(t,
+		"7e6efde762912ba9",
+		fmt.Sprintf("%x", must(CalcCryptoHashWithOptions(v1Opts, sample))[:8]),
+	)
+	require.Equal(t,
+		"7e6efde762912ba9",
+		fmt.Sprintf("%x", must(CalcCryptoHash(sample))[:8]),
+	)
+
+	hash := func(args ...any) Hash {
+		return must(CalcCryptoHashWithOptions(v2Opts, args...))
+	}
+	require.Equal(t, hash(testSample()), hash(testSample()))
+	require.NotEqual(t, hash("ab", "c"), hash("a", "bc"))
+	require.NotEqual(t, hash(map[string]string{}), hash(map[string]string{"": ""}))
+	require.NotEqual(t, hash(struct{ A, B *int }{A: new(int)}), hash(struct{ A, B *int }{B: new(int)}))
+	require.NotEqual(t, hash([]int{1, 2}), hash([]int{1, 3}))
+
+	_, err := CalcCryptoHashWithOptions([]Option{OptionHashFormat(100)}, 1)
+	require.Error(t, err)
+}
+
+func BenchmarkCalcCryptoHash(b *testing.B) {
+	sample := make([]uint64, 1024)
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		b.Run(format.String(), func(b *testing.B) {
+			opts := []Option{OptionHashFormat(format)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				must(CalcCryptoHashWithOptions(opts, sample))
+			}
+		})
+	}
+}
+
+func TestHashBulkPrimitives(t *testing.T) {
+	sample := []uint16{1, 2, 0xffff}
+
+	manual := NewHashBuilderStable(newSecureHash(), OptionHashFormat(HashFormatV2))
+	require.NoError(t, manual.writeString(typeDescriptor(reflect.TypeOf(sample))))
+	require.NoError(t, manual.writeLength(len(sample)))
+	for _, v := range sample {
+		require.NoError(t, manual.writeUint16(v))
+	}
+
+	opts := []Option{OptionHashFormat(HashFormatV2)}
+	require.Equal(t, Hash(manual.Result()), must(CalcCryptoHashWithOptions(opts, sample)))
+
+	hash := func(v any) Hash {
+		return must(CalcCryptoHashWithOptions(opts, v))
+	}
+	require.Equal(t, hash([4]byte{1, 2, 3, 4}), hash([4]byte{1, 2, 3, 4}))
+	require.NotEqual(t, hash([4]byte{1, 2, 3, 4}), hash([4]byte{1, 2, 3, 5}))
+	require.NotEqual(t, hash(map[int8][2]float64{1: {1, 2}}), hash(map[int8][2]float64{1: {1, 3}}))
+	require.NotEqual(t, hash([]bool{true, false}), hash([]bool{false, true}))
+
+	// the elements with custom hashing are not written as a block
+	require.Equal(t, hash(testCelsius(11)), hash(testCelsius(12)))
+	require.Equal(t, hash([]testCelsius{11, 20}), hash([]testCelsius{12, 20}))
+	require.Equal(t, hash([2]testCelsius{11, 20}), hash([2]testCelsius{12, 20}))
+	require.NotEqual(t, hash([]testCelsius{11, 20}), hash([]testCelsius{11, 30}))
+
+	RegisterHashFunc(func(b *HashBuilder, v int64) error {
+		return b.writeUint64(uint64(v / 10))
+	})
+	t.Cleanup(UnregisterHashFunc[int64])
+	require.Equal(t, hash(int64(11)), hash(int64(12)))
+	require.Equal(t, hash([]int64{11, 20}), hash([]int64{12, 20}))
+	require.NotEqual(t, hash([]int64{11, 20}), hash([]int64{11, 30}))
+}
+
+// testCelsius is hashed with the precision of 10 degrees.
+type testCelsius int64
+
+func (c testCelsius) WriteObjectHash(b *HashBuilder) error {
+	return b.writeUint64(uint64(c / 10))
+}
+
+func BenchmarkCalcCryptoHashBytes(b *testing.B) {
+	sample := make([]byte, 1<<20)
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		b.Run(format.String(), func(b *testing.B) {
+			data := sample
+			if format == HashFormatV1 {
+				// too slow for a megabyte
+				data = data[:1<<10]
+			}
+			opts := []Option{OptionHashFormat(format)}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				must(CalcCryptoHashWithOptions(opts, data))
+			}
+		})
+	}
+}
+
+func TestHashPlatformSizedInts(t *testing.T) {
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		t.Run(format.String(), func(t *testing.T) {
+			writeManually := func(v uint64) Hash {
+				b := NewHashBuilderStable(newSecureHash(), OptionHashFormat(format))
+				require.NoError(t, b.writeUint64(v))
+				return b.Result()
+			}
+			hashValue := func(v any, opts ...Option) (Hash, error) {
+				b := NewHashBuilderStable(newSecureHash(), append(opts, OptionHashFormat(format))...)
+				_, err := b.writeValue(reflect.ValueOf(v))
+				return b.Result(), err
+			}
+
+			require.Equal(t, writeManually(uint64(0xffffffffffffffff)), must(hashValue(int(-1))))
+			require.Equal(t, writeManually(1<<40), must(hashValue(uint(1<<40))))
+			require.Equal(t, writeManually(7), must(hashValue(uintptr(7))))
+
+			_, err := hashValue(int(1<<40), OptionIntOverflow(IntOverflowError))
+			require.ErrorIs(t, err, ErrIntOverflow)
+			_, err = hashValue(uint(1<<32), OptionIntOverflow(IntOverflowError))
+			require.ErrorIs(t, err, ErrIntOverflow)
+			_, err = hashValue(int(-1<<31), OptionIntOverflow(IntOverflowError))
+			require.NoError(t, err)
+		})
+	}
+
+	opts := []Option{OptionHashFormat(HashFormatV2)}
+	require.NotEqual(t,
+		must(CalcCryptoHashWithOptions(opts, []int{1, 2})),
+		must(CalcCryptoHashWithOptions(opts, []int{1, 3})),
+	)
+	_, err := CalcCryptoHashWithOptions(
+		append(opts, OptionIntOverflow(IntOverflowError)),
+		[]int{1, 1 << 40},
+	)
+	require.ErrorIs(t, err, ErrIntOverflow)
+}
+
+func TestHashFieldTags(t *testing.T) {
+	type sample struct {
+		Value   int
+		Updated int64 `hash:"-"`
+		cache   []byte
+	}
+
+	for _, newBuilder := range []func(opts ...Option) *HashBuilder{
+		func(opts ...Option) *HashBuilder { return NewHashBuilderStable(newSecureHash(), opts...) },
+		func(opts ...Option) *HashBuilder { return NewHashBuilderUnstable(newSecureHash(), opts...) },
+	} {
+		hash := func(v any, opts ...Option) Hash {
+			b := newBuilder(opts...)
+			require.NoError(t, b.Write(v))
+			return b.Result()
+		}
+		require.Equal(t, hash(sample{Value: 1, Updated: 1}), hash(sample{Value: 1, Updated: 2}))
+		require.NotEqual(t, hash(sample{Value: 1}), hash(sample{Value: 2}))
+		require.Equal(t,
+			hash(sample{Value: 1, Updated: 1}, OptionWithUnexported(true)),
+			hash(sample{Value: 1, Updated: 2}, OptionWithUnexported(true)),
+		)
+		require.Error(t, newBuilder().Write(struct {
+			A int `hash:"unknown"`
+		}{}))
+	}
+
+	// the types are different, so only the stable hashes could be equal
+	require.Equal(t,
+		must(CalcCryptoHash(struct {
+			A int
+			B int `hash:"-"`
+		}{A: 1, B: 1})),
+		must(CalcCryptoHash(struct{ A int }{A: 1})),
+	)
+	require.Equal(t,
+		must(CalcCryptoHash(struct {
+			A int `hash:"name=a"`
+		}{A: 1})),
+		must(CalcCryptoHash(struct {
+			B int `hash:"name=a"`
+		}{B: 1})),
+	)
+	require.NotEqual(t,
+		must(CalcCryptoHash(struct {
+			A int `hash:"name=a"`
+		}{A: 1})),
+		must(CalcCryptoHash(struct {
+			A int `hash:"name=b"`
+		}{A: 1})),
+	)
+}
+
+type testSet []string
+
+func (s testSet) WriteObjectHash(b *HashBuilder) error {
+	sorted := slices.Clone(s)
+	slices.Sort(sorted)
+	return b.Write([]string(sorted))
+}
+
+type testOtherSet []string
+
+func (s testOtherSet) WriteObjectHash(b *HashBuilder) error {
+	return testSet(s).WriteObjectHash(b)
+}
+
+type testFailingHasher struct{}
+
+func (testFailingHasher) WriteObjectHash(b *HashBuilder) error {
+	return fmt.Errorf("some error")
+}
+
+func TestObjectHasher(t *testing.T) {
+	type container struct {
+		Set   testSet
+		Value any
+	}
+
+	for _, newBuilder := range []func() *HashBuilder{
+		// HashFormatV2 to hash the contents of interfaces
+		func() *HashBuilder { return NewHashBuilderStable(newSecureHash(), OptionHashFormat(HashFormatV2)) },
+		func() *HashBuilder { return NewHashBuilderUnstable(newSecureHash(), OptionHashFormat(HashFormatV2)) },
+	} {
+		hash := func(v any) Hash {
+			b := newBuilder()
+			require.NoError(t, b.Write(v))
+			return b.Result()
+		}
+		require.Equal(t, hash(testSet{"a", "b"}), hash(testSet{"b", "a"}))
+		require.NotEqual(t, hash(testSet{"a", "b"}), hash(testSet{"a", "c"}))
+		require.NotEqual(t, hash(testSet{"a", "b"}), hash(testOtherSet{"a", "b"}))
+		require.Equal(t,
+			hash(container{Set: testSet{"a", "b"}, Value: testSet{"c", "d"}}),
+			hash(container{Set: testSet{"b", "a"}, Value: testSet{"d", "c"}}),
+		)
+		require.NotEqual(t,
+			hash(container{Set: testSet{"a", "b"}, Value: testSet{"c", "d"}}),
+			hash(container{Set: testSet{"a", "b"}, Value: testOtherSet{"c", "d"}}),
+		)
+		require.Equal(t, hash((*testSet)(nil)), hash((*testSet)(nil)))
+		require.Error(t, newBuilder().Write(container{Value: testFailingHasher{}}))
+	}
+}
+
+type testOpaque struct {
+	value int
+}
+
+func TestHashStdTypes(t *testing.T) {
+	opts := []Option{OptionHashFormat(HashFormatV2)}
+	hash := func(v any, extraOpts ...Option) Hash {
+		return must(CalcCryptoHashWithOptions(append(opts, extraOpts...), v))
+	}
+
+	now := time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC)
+	require.Equal(t, hash(now), hash(now.In(time.FixedZone("X", 3600))))
+	require.NotEqual(t, hash(now), hash(now.Add(time.Nanosecond)))
+	require.NotEqual(t,
+		hash(now, OptionHashTimeLocation(true)),
+		hash(now.In(time.FixedZone("X", 3600)), OptionHashTimeLocation(true)),
+	)
+
+	require.Equal(t, hash(big.NewInt(42)), hash(new(big.Int).SetBytes([]byte{42})))
+	require.NotEqual(t, hash(big.NewInt(42)), hash(big.NewInt(-42)))
+	require.NotEqual(t, hash(*big.NewInt(42)), hash(*big.NewInt(43)))
+	require.Equal(t,
+		hash(new(big.Float).SetPrec(10).SetInt64(5)),
+		hash(new(big.Float).SetPrec(100).SetInt64(5)),
+	)
+	require.NotEqual(t, hash(big.NewFloat(1.5)), hash(big.NewFloat(2.5)))
+
+	require.NotEqual(t, hash(netip.MustParseAddr("1.2.3.4")), hash(netip.MustParseAddr("1.2.3.5")))
+	require.NotEqual(t,
+		hash(must(url.Parse("http://user:a@host/"))),
+		hash(must(url.Parse("http://user:b@host/"))),
+	)
+	require.NotEqual(t, hash(regexp.MustCompile("a+")), hash(regexp.MustCompile("b+")))
+	require.NotEqual(t,
+		hash(struct{ T time.Time }{now}),
+		hash(struct{ T time.Time }{now.Add(time.Second)}),
+	)
+
+	// the same through the default entry point of HashFormatV2
+	for _, pair := range [][2]any{
+		{now, now.Add(time.Nanosecond)},
+		{struct{ T time.Time }{now}, struct{ T time.Time }{now.Add(time.Second)}},
+		{big.NewInt(42), big.NewInt(43)},
+		{*big.NewInt(42), *big.NewInt(43)},
+		{big.NewFloat(1.5), big.NewFloat(2.5)},
+		{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("1.2.3.5")},
+		{*must(url.Parse("http://user:a@host/")), *must(url.Parse("http://user:b@host/"))},
+		{regexp.MustCompile("a+"), regexp.MustCompile("b+")},
+	} {
+		require.NotEqual(t, must(CalcCryptoHashV2(pair[0])), must(CalcCryptoHashV2(pair[1])), "%T", pair[0])
+		require.Equal(t, hash(pair[0]), must(CalcCryptoHashV2(pair[0])), "%T", pair[0])
+	}
+	require.Equal(t, must(CalcCryptoHashV2(now)), must(CalcCryptoHashV2(now.In(time.FixedZone("X", 3600)))))
+
+	// without the unexported fields the value is opaque
+	noUnexported := OptionWithUnexported(false)
+	require.Equal(t, hash(testOpaque{value: 1}, noUnexported), hash(testOpaque{value: 2}, noUnexported))
+	RegisterHashFunc(func(b *HashBuilder, v testOpaque) error {
+		return b.Write(v.value)
+	})
+	t.Cleanup(UnregisterHashFunc[testOpaque])
+	require.NotEqual(t, hash(testOpaque{value: 1}, noUnexported), hash(testOpaque{value: 2}, noUnexported))
+	require.NotEqual(t,
+		must(CalcCryptoHash(testOpaque{value: 1})),
+		must(CalcCryptoHash(testOpaque{value: 2})),
+	)
+
+	UnregisterHashFunc[testOpaque]()
+	require.Equal(t, hash(testOpaque{value: 1}, noUnexported), hash(testOpaque{value: 2}, noUnexported))
+}
+
+func TestCalcHash64(t *testing.T) {
+	sample := testSample()
+	for _, alg := range []HashAlgorithm{
+		HashAlgorithmUndefined,
+		HashAlgorithmSecure,
+		HashAlgorithmFNV64a,
+		HashAlgorithmMapHash,
+	} {
+		t.Run(alg.String(), func(t *testing.T) {
+			opts := []Option{OptionHashAlgorithm(alg)}
+			h := must(CalcHash64(sample, opts...))
+			require.Equal(t, h, must(CalcHash64(testSample(), opts...)))
+			require.NotEqual(t, h, must(CalcHash64(testSampleWithoutSecrets(), opts...)))
+			require.NotEqual(t, must(CalcHash64(1, opts...)), must(CalcHash64(2, opts...)))
+			require.Equal(t,
+				must(CalcHashWithOptions(opts, map[string]int{"a": 1, "b": 2})),
+				must(CalcHashWithOptions(opts, map[string]int{"b": 2, "a": 1})),
+			)
+		})
+	}
+
+	manual := NewHashBuilderStable(fnv.New64a(), OptionHashFormat(HashFormatV2))
+	require.NoError(t, manual.Write("hello"))
+	require.Equal(t, binary.BigEndian.Uint64(manual.Result()), must(CalcHash64("hello")))
+
+	unknownAlg := OptionHashAlgorithm(endOfHashAlgorithm)
+	_, err := CalcHash64(1, unknownAlg)
+	require.Error(t, err)
+	_, err = CalcHashWithOptions([]Option{unknownAlg}, 1)
+	require.Error(t, err)
+	_, err = CalcHashWithOptions([]Option{unknownAlg})
+	require.Error(t, err)
+	for _, b := range []*HashBuilder{
+		NewHashBuilderStable(nil, unknownAlg),
+		NewHashBuilderUnstable(nil, unknownAlg),
+	} {
+		b.Reset()
+		require.Error(t, b.Write(1))
+		_, err = b.ResetAndHash(1)
+		require.Error(t, err)
+		require.Nil(t, b.Result())
+	}
+}
+
+func BenchmarkCalcHash64(b *testing.B) {
+	sample := testSample()
+	for _, alg := range []HashAlgorithm{HashAlgorithmSecure, HashAlgorithmFNV64a, HashAlgorithmMapHash} {
+		b.Run(alg.String(), func(b *testing.B) {
+			opt := OptionHashAlgorithm(alg)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				must(CalcHash64(sample, opt))
+			}
+		})
+	}
+}
+
+func TestHashUnordered(t *testing.T) {
+	type set struct {
+		Items []string `hash:"unordered"`
+		Array [3]int   `hash:"unordered"`
+		List  []string
+	}
+
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		t.Run(format.String(), func(t *testing.T) {
+			opts := []Option{OptionHashFormat(format)}
+			hash := func(v any) Hash {
+				return must(CalcCryptoHashWithOptions(opts, v))
+			}
+			require.Equal(t,
+				hash(set{Items: []string{"a", "b", "c"}, Array: [3]int{1, 2, 3}}),
+				hash(set{Items: []string{"c", "a", "b"}, Array: [3]int{3, 1, 2}}),
+			)
+			require.NotEqual(t,
+				hash(set{Items: []string{"a", "a", "b"}}),
+				hash(set{Items: []string{"a", "b", "b"}}),
+			)
+			require.NotEqual(t,
+				hash(set{Items: []string{"a", "b"}}),
+				hash(set{Items: []string{"a", "b", "c"}}),
+			)
+			require.NotEqual(t,
+				hash(set{List: []string{"a", "b"}}),
+				hash(set{List: []string{"b", "a"}}),
+			)
+
+			_, err := CalcCryptoHashWithOptions(opts, struct {
+				A int `hash:"unordered"`
+			}{})
+			require.Error(t, err)
+		})
+	}
+
+	opts := []Option{OptionHashFormat(HashFormatV2)}
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[fmt.Sprint(i)] = i
+	}
+	h := must(CalcCryptoHashWithOptions(opts, m))
+	for i := 0; i < 10; i++ {
+		require.Equal(t, h, must(CalcCryptoHashWithOptions(opts, maps.Clone(m))))
+	}
+	m["0"] = 1
+	require.NotEqual(t, h, must(CalcCryptoHashWithOptions(opts, m)))
+
+	// the elements are hashed by the same hash function as the whole value
+	fnvOpts := append(opts[:len(opts):len(opts)], OptionHashAlgorithm(HashAlgorithmFNV64a))
+	require.Equal(t, must(CalcCryptoHashWithOptions(opts, m)), must(CalcCryptoHashWithOptions(fnvOpts, m)))
+	require.IsType(t, fnv.New64a(), NewHashBuilderStable(fnv.New64a(), opts...).newHash())
+	require.IsType(t, fnv.New64a(), NewHashBuilderStable(nil, fnvOpts...).newHash())
+	keyedSub := NewHashBuilderKeyed([]byte("key"), opts...).newHash().(*secureHash)
+	require.True(t, keyedSub.IsKeyed)
+	require.Equal(t, []byte("key"), keyedSub.Key)
+	require.False(t, newSecureHash().newEmpty().(*secureHash).IsKeyed)
+	b := NewHashBuilderStable(fnv.New64a(), opts...)
+	require.NoError(t, b.Write(m))
+	require.Equal(t, must(CalcHashWithOptions(fnvOpts, m)), Hash(b.Result()))
+}
+
+func BenchmarkCalcCryptoHashMap(b *testing.B) {
+	m := map[string]int{}
+	for i := 0; i < 100; i++ {
+		m[fmt.Sprint(i)] = i
+	}
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		b.Run(format.String(), func(b *testing.B) {
+			opts := []Option{OptionHashFormat(format)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				must(CalcCryptoHashWithOptions(opts, m))
+			}
+		})
+	}
+}
+
+func TestCalcKeyedCryptoHash(t *testing.T) {
+	sample := testSample()
+	key0 := []byte("key0")
+	key1 := []byte("key1")
+
+	h := must(CalcKeyedCryptoHash(key0, sample))
+	require.Len(t, h, 128)
+	require.Equal(t, h, must(CalcKeyedCryptoHash(key0, testSample())))
+	require.NotEqual(t, h, must(CalcKeyedCryptoHash(key0, testSampleWithoutSecrets())))
+	require.NotEqual(t, h, must(CalcKeyedCryptoHash(key1, sample)))
+	require.NotEqual(t, h, must(CalcCryptoHash(sample)))
+	require.NotEqual(t, h, must(CalcCryptoHashV2(sample)))
+	require.NotEqual(t,
+		must(CalcKeyedCryptoHash(key0, map[string]int{"a": 1})),
+		must(CalcKeyedCryptoHash(key0, map[string]int{"a": 2})),
+	)
+
+	b := NewHashBuilderKeyed(key0)
+	require.NoError(t, b.Write(sample))
+	require.Equal(t, h, Hash(b.Result()))
+	b.Reset()
+	require.NoError(t, b.Write(sample))
+	require.Equal(t, h, Hash(b.Result()))
+
+	b = NewHashBuilderKeyed(key0, OptionHashFormat(HashFormatV1))
+	require.NoError(t, b.Write(sample))
+	require.NotEqual(t, h, Hash(b.Result()))
+
+	for _, key := range [][]byte{nil, {}} {
+		_, err := CalcKeyedCryptoHash(key, sample)
+		require.ErrorIs(t, err, ErrEmptyKey)
+		b := NewHashBuilderKeyed(key)
+		require.ErrorIs(t, b.Write(sample), ErrEmptyKey)
+		require.Nil(t, b.Result())
+	}
+
+	// a keyed hash with a lost key is not keyed silently
+	keyed := must(newKeyedSecureHash(key0))
+	keyed.Key = nil
+	require.Nil(t, keyed.newEmpty())
+	b = NewHashBuilderStable(keyed, OptionHashFormat(HashFormatV2))
+	require.Error(t, b.Write(map[string]int{"a": 1}))
+}
+
+func TestHashFloatCanonicalization(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+	otherNaN := math.Float64frombits(0x7ff8000000000002)
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		t.Run(format.String(), func(t *testing.T) {
+			hash := func(v any, c FloatCanonicalization) Hash {
+				return must(CalcCryptoHashWithOptions([]Option{
+					OptionHashFormat(format),
+					OptionFloatCanonicalization(c),
+				}, v))
+			}
+
+			none := FloatCanonicalization{}
+			require.NotEqual(t, hash(0.0, none), hash(negZero, none))
+			require.NotEqual(t, hash(math.NaN(), none), hash(otherNaN, none))
+			require.NotEqual(t, hash([]float64{0}, none), hash([]float64{negZero}, none))
+
+			c := FloatCanonicalization{FoldSignedZero: true, CanonicalNaN: true}
+			require.Equal(t, hash(0.0, c), hash(negZero, c))
+			require.Equal(t, hash(float32(0), c), hash(float32(negZero), c))
+			require.Equal(t, hash(math.NaN(), c), hash(otherNaN, c))
+			require.Equal(t, hash(math.NaN(), c), hash(-math.NaN(), c))
+			require.Equal(t, hash(complex(negZero, otherNaN), c), hash(complex(0, math.NaN()), c))
+			require.Equal(t, hash([]float64{negZero, otherNaN}, c), hash([]float64{0, math.NaN()}, c))
+			require.Equal(t, hash([2]float32{float32(negZero)}, c), hash([2]float32{}, c))
+			require.NotEqual(t, hash(1.0, c), hash(2.0, c))
+
+			digits := FloatCanonicalization{SignificantDigits: 3}
+			require.Equal(t, hash(1.2341, digits), hash(1.2339, digits))
+			require.NotEqual(t, hash(1.234, digits), hash(1.236, digits))
+			require.Equal(t, hash(float32(123456), digits), hash(float32(123401), digits))
+
+			epsilon := FloatCanonicalization{Epsilon: 0.5, FoldSignedZero: true}
+			require.Equal(t, hash(1.1, epsilon), hash(0.9, epsilon))
+			require.NotEqual(t, hash(1.1, epsilon), hash(1.4, epsilon))
+			require.Equal(t, hash(-0.1, epsilon), hash(0.1, epsilon))
+			require.Equal(t, hash(complex64(complex(1.1, 2.1)), epsilon), hash(complex64(complex(0.9, 1.9)), epsilon))
+		})
+	}
+}
+
+func TestHashNilAndSharing(t *testing.T) {
+	type node struct {
+		Value int
+		Next  *node
+	}
+	type graph struct {
+		A, B, C *node
+	}
+
+	for _, format := range []HashFormat{HashFormatV1, HashFormatV2} {
+		t.Run(format.String(), func(t *testing.T) {
+			hash := func(v any, opts ...Option) Hash {
+				return must(CalcCryptoHashWithOptions(append(opts, OptionHashFormat(format)), v))
+			}
+
+			require.Equal(t, hash([]int(nil)), hash([]int{}))
+			require.Equal(t, hash(map[int]int(nil)), hash(map[int]int{}))
+			explicitNil := OptionHashExplicitNil(true)
+			require.NotEqual(t, hash([]int(nil), explicitNil), hash([]int{}, explicitNil))
+			require.NotEqual(t, hash(map[int]int(nil), explicitNil), hash(map[int]int{}, explicitNil))
+			require.NotEqual(t, hash((*int)(nil), explicitNil), hash(new(int), explicitNil))
+			require.Equal(t, hash([]int{}, explicitNil), hash([]int{}, explicitNil))
+
+			p, q := &node{Value: 1}, &node{Value: 1}
+			// the same topology, but the third field points to different nodes:
+			require.Equal(t, hash(graph{p, q, p}), hash(graph{p, q, q}))
+			sharing := OptionHashPointerSharing(true)
+			require.NotEqual(t, hash(graph{p, q, p}, sharing), hash(graph{p, q, q}, sharing))
+			require.NotEqual(t, hash(graph{p, p, nil}, sharing), hash(graph{p, q, nil}, sharing))
+			require.Equal(t, hash(graph{p, q, p}, sharing), hash(graph{q, p, q}, sharing))
+
+			loop0 := &node{Value: 1}
+			loop0.Next = loop0
+			loop1 := &node{Value: 1}
+			loop1.Next = loop1
+			require.Equal(t, hash(loop0, sharing), hash(loop1, sharing))
+			require.NotEqual(t, hash(loop0, sharing), hash(&node{Value: 1, Next: &node{Value: 1}}, sharing))
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package object
+
+import (
+	"encoding/binary"
+	"reflect"
+	"unsafe"
+)
+
+var isNativeLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// isBulkHashableKind returns true if the in-memory representation of
+// a value of the kind is the same as its encoding in the hash (given
+// the byte order is the native one) on any platform.
+func isBulkHashableKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64,
+		reflect.Complex64, reflect.Complex128:
+		return true
+	default:
+		return false
+	}
+}
+
+// rawPrimitives returns the memory of the elements of slice or array `v`
+// if the memory could be written into the hash as is, instead of
+// writing the elements one by one (which produces the same byte stream,
+// but is orders of magnitude slower).
+//
+// It is applicable only to the formats newer than HashFormatV1 (where
+// the types of the elements are not written), and only to the elements
+// without custom hashing (see RegisterHashFunc and ObjectHasher).
+func (b *HashBuilder) rawPrimitives(v reflect.Value) ([]byte, bool) {
+	if b.hashFormat() == HashFormatV1 {
+		return nil, false
+	}
+	elemT := v.Type().Elem()
+	if mayHaveCustomHashFunc(elemT) {
+		// every element needs to be hashed by its own function
+		return nil, false
+	}
+	switch elemT.Kind() {
+	case reflect.Int, reflect.Uint, reflect.Uintptr:
+		// in the stable mode they are written as 64-bit values
+		// (and the overflows may need to be checked)
+		if b.StableHashing && (elemT.Size() != 8 || b.config.IntOverflowPolicy != IntOverflowIgnore) {
+			return nil, false
+		}
+	case reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		if b.config.FloatCanonicalization.isEnabled() {
+			// every value needs to be normalized
+			return nil, false
+		}
+	default:
+		if !isBulkHashableKind(elemT.Kind()) {
+			return nil, false
+		}
+	}
+	if elemT.Size() > 1 && b.StableHashing && !isNativeLittleEndian {
+		// the byte order needs to be converted
+		return nil, false
+	}
+	if v.Len() == 0 {
+		return nil, true
+	}
+
+	var ptr unsafe.Pointer
+	switch v.Kind() {
+	case reflect.Slice:
+		ptr = v.UnsafePointer()
+	case reflect.Array:
+		if !v.CanAddr() {
+			if !v.CanInterface() {
+				return nil, false
+			}
+			vWithAddr := reflect.New(v.Type()).Elem()
+			vWithAddr.Set(v)
+			v = vWithAddr
+		}
+		ptr = unsafe.Pointer(v.UnsafeAddr())
+	default:
+		return nil, false
+	}
+	return unsafe.Slice((*byte)(ptr), uintptr(v.Len())*elemT.Size()), true
+}
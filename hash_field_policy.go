@@ -0,0 +1,66 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// hashFieldPolicy is the hashing behavior of a struct field,
+// defined by tag `hash:"..."`:
+//
+//   - `hash:"-"` excludes the field from the hash;
+//   - `hash:"name=foo"` identifies the field in the hash by name "foo"
+//     instead of its Go name, so the hash survives renaming the field;
+//   - `hash:"unordered"` hashes a slice or an array as a multiset:
+//     the order of the elements does not matter.
+//
+// Options could be combined using a comma, e.g. `hash:"name=foo,unordered"`.
+type hashFieldPolicy struct {
+	Skip      bool
+	Name      string
+	Unordered bool
+
+	// Err is the error of parsing the tag (if any).
+	Err error
+}
+
+func parseHashFieldPolicy(tag reflect.StructTag) hashFieldPolicy {
+	value, ok := tag.Lookup("hash")
+	if !ok {
+		return hashFieldPolicy{}
+	}
+	if value == "-" {
+		return hashFieldPolicy{Skip: true}
+	}
+	var policy hashFieldPolicy
+	for _, opt := range strings.Split(value, ",") {
+		switch {
+		case opt == "":
+		case opt == "unordered":
+			policy.Unordered = true
+		case strings.HasPrefix(opt, "name="):
+			policy.Name = strings.TrimPrefix(opt, "name=")
+			if policy.Name == "" {
+				policy.Err = fmt.Errorf("empty name in tag `hash:\"%s\"`", value)
+			}
+		default:
+			policy.Err = fmt.Errorf("unknown option '%s' in tag `hash:\"%s\"`", opt, value)
+		}
+	}
+	return policy
+}
+
+var hashFieldPolicies sync.Map // reflect.StructTag -> *hashFieldPolicy
+
+// getHashFieldPolicy returns the hashing policy of a struct field
+// with tag `tag`.
+func getHashFieldPolicy(tag reflect.StructTag) *hashFieldPolicy {
+	if policy, ok := hashFieldPolicies.Load(tag); ok {
+		return policy.(*hashFieldPolicy)
+	}
+	policy := parseHashFieldPolicy(tag)
+	hashFieldPolicies.Store(tag, &policy)
+	return &policy
+}
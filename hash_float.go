@@ -0,0 +1,64 @@
+package object
+
+import (
+	"math"
+	"strconv"
+)
+
+// FloatCanonicalization defines how floating-point values (including
+// the parts of complex values) are normalized before being hashed,
+// so the values which are considered equal have the same hash
+// (see OptionFloatCanonicalization).
+type FloatCanonicalization struct {
+	// FoldSignedZero makes -0.0 be hashed as +0.0.
+	FoldSignedZero bool
+
+	// CanonicalNaN makes all the NaN-s be hashed the same way
+	// (independently of the sign and the payload).
+	CanonicalNaN bool
+
+	// SignificantDigits (if positive) rounds the values to the given
+	// number of significant decimal digits.
+	SignificantDigits int
+
+	// Epsilon (if positive) rounds the values to the nearest multiple
+	// of Epsilon. It is applied after SignificantDigits.
+	Epsilon float64
+}
+
+// isEnabled returns true if any normalization is requested.
+func (c FloatCanonicalization) isEnabled() bool {
+	return c != FloatCanonicalization{}
+}
+
+// canonicalize returns the normalized value of `v`.
+func (c FloatCanonicalization) canonicalize(v float64, bitSize int) float64 {
+	if c.SignificantDigits > 0 && !math.IsNaN(v) && !math.IsInf(v, 0) {
+		// the decimal representation is the exact way to round decimal digits
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', c.SignificantDigits, bitSize), bitSize)
+	}
+	if c.Epsilon > 0 && !math.IsNaN(v) && !math.IsInf(v, 0) {
+		v = math.Round(v/c.Epsilon) * c.Epsilon
+	}
+	if c.FoldSignedZero && v == 0 {
+		v = 0
+	}
+	if c.CanonicalNaN && math.IsNaN(v) {
+		v = math.NaN()
+	}
+	return v
+}
+
+func (b *HashBuilder) canonicalFloat32(v float32) float32 {
+	if !b.config.FloatCanonicalization.isEnabled() {
+		return v
+	}
+	return float32(b.config.FloatCanonicalization.canonicalize(float64(v), 32))
+}
+
+func (b *HashBuilder) canonicalFloat64(v float64) float64 {
+	if !b.config.FloatCanonicalization.isEnabled() {
+		return v
+	}
+	return b.config.FloatCanonicalization.canonicalize(v, 64)
+}
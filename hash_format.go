@@ -0,0 +1,84 @@
+package object
+
+import (
+	"fmt"
+)
+
+// HashFormat is the version of the encoding of values, which is fed into
+// the hash function by HashBuilder. Different formats produce different
+// hashes for the same values, so the format should be fixed if the hashes
+// are persisted.
+type HashFormat int
+
+const (
+	// HashFormatUndefined is the zero value, it means HashFormatV1.
+	HashFormatUndefined = HashFormat(iota)
+
+	// HashFormatV1 is the legacy format: every written primitive is
+	// mixed into the hash by feeding the current digest back into
+	// the hash function (so the hash is finalized on every primitive).
+	//
+	// It is the default of CalcCryptoHash and NewHashBuilderStable to do
+	// not change the already persisted hashes, so it keeps the weaknesses
+	// of the original encoding:
+	//   - unnamed types (like []int and map[string]int) have the same
+	//     type marker;
+	//   - only the number of entries of a map is hashed;
+	//   - only the static type of an interface value is hashed;
+	//   - unexported fields are not hashed by default, so the values of
+	//     the standard types without exported fields (like time.Time
+	//     and big.Int) are not hashed at all.
+	//
+	// The features which are enabled explicitly (options, tags
+	// `hash:"..."`, ObjectHasher, RegisterHashFunc) are supported in this
+	// format as well, but the canonical representations of the standard
+	// types (see HashFormatV2) are not.
+	//
+	// To migrate, use CalcCryptoHashV2 (or OptionHashFormat(HashFormatV2))
+	// and recalculate the persisted hashes.
+	HashFormatV1
+
+	// HashFormatV2 is a streaming format: all the values are encoded into
+	// an unambiguous (length-prefixed) canonical byte stream, which is
+	// written into a single hash instance and finalized only once.
+	// It is orders of magnitude faster than HashFormatV1. It is the default
+	// of CalcCryptoHashV2, CalcKeyedCryptoHash and CalcHash64.
+	//
+	// The differences from HashFormatV1:
+	//   - the type is written only for the root value and for the values
+	//     inside interfaces (the other types are implied by the parents),
+	//     and it is described structurally, so unnamed types (like []int
+	//     and map[string]int) are not confused (but defined types are
+	//     described by the package path and the name only, so the types
+	//     declared inside functions with the same name are confused, they
+	//     should be declared at the package level if it matters);
+	//   - strings (including type descriptors) are prefixed with the length;
+	//   - the number of entries of a map is written before the entries,
+	//     and the entries are combined in an order-independent way
+	//     (see `hash:"unordered"`);
+	//   - the dynamic type and the value inside an interface are written
+	//     (errors are hashed by their contents, not by their messages),
+	//     a nil interface is distinguished from a typed nil;
+	//   - nil-ness of a pointer is written before the value behind it;
+	//   - unexported fields are hashed (unless OptionWithUnexported(false)
+	//     is provided), identified by the package path and the name;
+	//   - opaque standard types (time.Time, big.Int, netip.Addr, ...) are
+	//     hashed by their canonical representation (see RegisterHashFunc).
+	HashFormatV2
+
+	endOfHashFormat
+)
+
+// String implements fmt.Stringer.
+func (f HashFormat) String() string {
+	switch f {
+	case HashFormatUndefined:
+		return "undefined"
+	case HashFormatV1:
+		return "v1"
+	case HashFormatV2:
+		return "v2"
+	default:
+		return fmt.Sprintf("unknown_format_%d", int(f))
+	}
+}
@@ -0,0 +1,165 @@
+package object
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/xaionaro-go/unsafetools"
+)
+
+// hashFunc writes value `v` into HashBuilder `b`.
+type hashFunc func(b *HashBuilder, v reflect.Value) error
+
+var (
+	hashFuncs    sync.Map // reflect.Type -> hashFunc
+	stdHashFuncs = map[reflect.Type]hashFunc{}
+)
+
+// RegisterHashFunc makes HashBuilder hash values of type T using `fn`
+// instead of traversing them (similar to implementing ObjectHasher,
+// but for types which could not be modified, for example the types
+// of other packages). The type of the value is still written into the hash
+// by the HashBuilder.
+//
+// A registered function takes precedence over ObjectHasher and
+// over the default handling of the standard types (see HashFormatV2).
+func RegisterHashFunc[T any](fn func(b *HashBuilder, v T) error) {
+	hashFuncs.Store(typeOf[T](), newHashFunc(fn))
+}
+
+// UnregisterHashFunc reverts RegisterHashFunc for type T.
+func UnregisterHashFunc[T any]() {
+	hashFuncs.Delete(typeOf[T]())
+}
+
+func registerStdHashFunc[T any](fn func(b *HashBuilder, v T) error) {
+	stdHashFuncs[typeOf[T]()] = newHashFunc(fn)
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func newHashFunc[T any](fn func(b *HashBuilder, v T) error) hashFunc {
+	return func(b *HashBuilder, v reflect.Value) error {
+		return fn(b, v.Interface().(T))
+	}
+}
+
+func init() {
+	registerStdHashFunc(func(b *HashBuilder, v time.Time) error {
+		// the instant only (unless OptionHashTimeLocation is set),
+		// the monotonic clock reading is ignored as well
+		if err := b.writeUint64(uint64(v.Unix())); err != nil {
+			return err
+		}
+		if err := b.writeUint32(uint32(v.Nanosecond())); err != nil {
+			return err
+		}
+		if !b.config.HashTimeLocation {
+			return nil
+		}
+		return b.writeString(v.Location().String())
+	})
+	registerStdHashFunc(func(b *HashBuilder, v big.Int) error {
+		if err := b.writeUint8(uint8(v.Sign())); err != nil {
+			return err
+		}
+		return b.writeString(unsafetools.CastBytesToString(v.Bytes()))
+	})
+	registerStdHashFunc(func(b *HashBuilder, v big.Float) error {
+		// the exact value, independently of the precision
+		return b.writeString(v.Text('p', 0))
+	})
+	registerStdHashFunc(func(b *HashBuilder, v url.URL) error {
+		return b.writeString(v.String())
+	})
+}
+
+var (
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// customHashFunc returns the function which writes value `v` into
+// a HashBuilder instead of traversing it, if there is any:
+//
+//   - a function registered by RegisterHashFunc;
+//   - method WriteObjectHash (see ObjectHasher);
+//   - (except HashFormatV1) the canonical representation of time.Time,
+//     big.Int, big.Float and url.URL;
+//   - (except HashFormatV1) method MarshalBinary or MarshalText, if the
+//     value is a struct without exported fields (which would be hashed
+//     the same way independently of its value otherwise; for example
+//     netip.Addr or regexp.Regexp).
+func (b *HashBuilder) customHashFunc(v reflect.Value) func(*HashBuilder) error {
+	t := v.Type()
+	if fn, ok := hashFuncs.Load(t); ok && v.CanInterface() {
+		return func(b *HashBuilder) error {
+			return fn.(hashFunc)(b, v)
+		}
+	}
+	if hasher, ok := asInterface(v, objectHasherType); ok {
+		return hasher.(ObjectHasher).WriteObjectHash
+	}
+	if b.hashFormat() == HashFormatV1 {
+		// kept as is for compatibility
+		return nil
+	}
+	if fn, ok := stdHashFuncs[t]; ok && v.CanInterface() {
+		return func(b *HashBuilder) error {
+			return fn(b, v)
+		}
+	}
+	if !hasNoExportedFields(t) {
+		return nil
+	}
+	if m, ok := asInterface(v, binaryMarshalerType); ok {
+		return func(b *HashBuilder) error {
+			data, err := m.(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				return fmt.Errorf("unable to marshal into binary: %w", err)
+			}
+			return b.writeString(unsafetools.CastBytesToString(data))
+		}
+	}
+	if m, ok := asInterface(v, textMarshalerType); ok {
+		return func(b *HashBuilder) error {
+			data, err := m.(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return fmt.Errorf("unable to marshal into text: %w", err)
+			}
+			return b.writeString(unsafetools.CastBytesToString(data))
+		}
+	}
+	return nil
+}
+
+// mayHaveCustomHashFunc returns true if values of type `t` could be
+// hashed by a registered function or by method WriteObjectHash (see
+// customHashFunc) instead of being encoded according to their kind.
+func mayHaveCustomHashFunc(t reflect.Type) bool {
+	if _, ok := hashFuncs.Load(t); ok {
+		return true
+	}
+	return t.Implements(objectHasherType) || reflect.PointerTo(t).Implements(objectHasherType)
+}
+
+// hasNoExportedFields returns true if `t` is a struct with fields,
+// but none of them is exported.
+func hasNoExportedFields(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() == 0 {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// writeUnordered writes `count` elements in an order-independent way:
+// every element is hashed separately (by `writeElem`), and the hashes are
+// summed up as little-endian integers modulo 2^(8*size) (a commutative
+// multiset hash), so any permutation of the same elements produces
+// the same result without sorting.
+func (b *HashBuilder) writeUnordered(
+	count int,
+	writeElem func(sub *HashBuilder, idx int) error,
+) error {
+	// the elements are hashed by the same hash function (otherwise
+	// the security properties of the whole hash could differ)
+	newHash := b.newHash
+	if newHash == nil {
+		newHash = b.config.HashAlgorithm.newHash
+	}
+	sub := &HashBuilder{
+		HashValue:     newHash(),
+		StableHashing: b.StableHashing,
+		byteOrder:     b.byteOrder,
+		config:        b.config,
+		newHash:       newHash,
+	}
+	if sub.HashValue == nil {
+		return fmt.Errorf("unable to create a new instance of the hash function (algorithm %s)", b.config.HashAlgorithm)
+	}
+	sum := make([]byte, sub.HashValue.Size())
+	for idx := 0; idx < count; idx++ {
+		sub.reset()
+		if err := writeElem(sub, idx); err != nil {
+			return err
+		}
+		addLittleEndian(sum, sub.result())
+	}
+	return b.extend(sum)
+}
+
+// addLittleEndian adds `v` to `sum` (both are little-endian integers
+// of the same size), dropping the overflow.
+func addLittleEndian(sum, v []byte) {
+	carry := uint(0)
+	for i := range sum {
+		carry += uint(sum[i]) + uint(v[i])
+		sum[i] = byte(carry)
+		carry >>= 8
+	}
+}
+
+// writeMapUnordered writes the entries of a map in an order-independent
+// way (see writeUnordered).
+func (b *HashBuilder) writeMapUnordered(v reflect.Value) error {
+	iter := v.MapRange()
+	return b.writeUnordered(v.Len(), func(sub *HashBuilder, _ int) error {
+		if !iter.Next() {
+			return fmt.Errorf("the map has changed during hashing")
+		}
+		key, value := iter.Key(), iter.Value()
+		if err := sub.writeReflectValue(key); err != nil {
+			return fmt.Errorf("unable to write map key of type '%s': %w", key.Type(), err)
+		}
+		if err := sub.writeReflectValue(value); err != nil {
+			return fmt.Errorf("unable to write map value of type '%s': %w", value.Type(), err)
+		}
+		return nil
+	})
+}
+
+// writeSliceUnordered writes the length and the elements of a slice
+// or an array in an order-independent way (see writeUnordered).
+func (b *HashBuilder) writeSliceUnordered(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+	default:
+		return fmt.Errorf("`hash:\"unordered\"` is applicable only to slices and arrays, but the kind is %s", v.Kind())
+	}
+	if err := b.writeLength(v.Len()); err != nil {
+		return err
+	}
+	return b.writeUnordered(v.Len(), func(sub *HashBuilder, idx int) error {
+		if err := sub.writeReflectValue(v.Index(idx)); err != nil {
+			return fmt.Errorf("unable to write element #%d: %w", idx, err)
+		}
+		return nil
+	})
+}
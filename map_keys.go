@@ -0,0 +1,95 @@
+package object
+
+import (
+	"reflect"
+)
+
+// MapKeyCollisionPolicy defines what happens if two different keys
+// of a map become equal after processing (for example, if they differ
+// only in fields tagged as `secret:""`, and the secrets are removed).
+type MapKeyCollisionPolicy int
+
+const (
+	// MapKeyCollisionError makes the processing fail with ErrMapKeyCollision.
+	MapKeyCollisionError = MapKeyCollisionPolicy(iota)
+
+	// MapKeyCollisionKeepAny keeps one of the colliding entries
+	// (it is not defined which one).
+	MapKeyCollisionKeepAny
+
+	// MapKeyCollisionDrop removes all the colliding entries.
+	MapKeyCollisionDrop
+)
+
+// mapFiller fills a map handling the collisions of keys
+// according to the policy.
+type mapFiller struct {
+	Map    reflect.Value
+	Policy MapKeyCollisionPolicy
+
+	collidedKeys []reflect.Value
+}
+
+// set is the same as `f.Map.SetMapIndex(k, v)`, but returns
+// ErrMapKeyCollision (depending on the policy) if key `k` is already set.
+func (f *mapFiller) set(k, v reflect.Value) error {
+	if f.Map.MapIndex(k).IsValid() {
+		switch f.Policy {
+		case MapKeyCollisionKeepAny:
+			return nil
+		case MapKeyCollisionDrop:
+			f.collidedKeys = append(f.collidedKeys, k)
+			return nil
+		default:
+			return ErrMapKeyCollision
+		}
+	}
+	f.Map.SetMapIndex(k, v)
+	return nil
+}
+
+// finish should be called after all the entries are set.
+func (f *mapFiller) finish() {
+	for _, k := range f.collidedKeys {
+		f.Map.SetMapIndex(k, reflect.Value{})
+	}
+	f.collidedKeys = f.collidedKeys[:0]
+}
+
+// movedMapKey is a key of a map, which was modified in-place (see Traverse).
+type movedMapKey struct {
+	Ctx *ProcContext
+	Old reflect.Value
+	New reflect.Value
+}
+
+// moveMapKeys replaces the keys `moved[*].Old` of map `m`
+// with keys `moved[*].New`.
+func moveMapKeys(
+	m reflect.Value,
+	moved []movedMapKey,
+	policy MapKeyCollisionPolicy,
+) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	values := make([]reflect.Value, len(moved))
+	for idx, k := range moved {
+		values[idx] = m.MapIndex(k.Old)
+	}
+	for _, k := range moved {
+		m.SetMapIndex(k.Old, reflect.Value{})
+	}
+	f := mapFiller{Map: m, Policy: policy}
+	for idx, k := range moved {
+		if !values[idx].IsValid() {
+			// the key is not equal to itself (NaN), so the entry is unreachable
+			continue
+		}
+		if err := f.set(k.New, values[idx]); err != nil {
+			return newPathError(k.Ctx, k.New.Type(), err)
+		}
+	}
+	f.finish()
+	return nil
+}
@@ -0,0 +1,504 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/xaionaro-go/unsafetools"
+)
+
+// MerkleNode is a node of the Merkle tree of an object (see CalcMerkleHash).
+type MerkleNode struct {
+	// Path is the path of the value in the object (see ProcContext.Path),
+	// the root has an empty path.
+	Path string
+
+	// Hash is the digest of the whole subtree.
+	Hash Hash
+
+	// Label identifies the node among the children of its parent: the name
+	// of the struct field, the index of the element, the hash of the map key.
+	Label []byte
+
+	// Header describes the value of an inner node (the type, the length,
+	// nil-ness), it is nil for leaves.
+	Header []byte
+
+	// Children are the struct fields, the elements of slices and arrays,
+	// the values of map entries (ordered by the labels), the value behind
+	// a pointer or inside an interface.
+	Children []*MerkleNode
+
+	parent    *MerkleNode
+	unordered bool
+}
+
+// IsLeaf returns true if the node is a leaf (a value hashed as a whole).
+func (n *MerkleNode) IsLeaf() bool {
+	return n.Header == nil
+}
+
+// Find returns the node of the value with path `path`
+// (see ProcContext.Path), or nil if there is no such node.
+func (n *MerkleNode) Find(path string) *MerkleNode {
+	if n.Path == path {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.Find(path); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Diff returns the paths of the topmost subtrees, which are different
+// in `n` and `other` (comparing the digests top-down). The children of
+// `hash:"unordered"` values are matched by their digests, so only
+// the elements without an equal counterpart are reported.
+func (n *MerkleNode) Diff(other *MerkleNode) []string {
+	if bytes.Equal(n.Hash, other.Hash) {
+		return nil
+	}
+	if n.IsLeaf() || other.IsLeaf() || !bytes.Equal(n.Header, other.Header) {
+		return []string{n.Path}
+	}
+	children, otherChildren := n.Children, other.Children
+	if n.unordered {
+		children, otherChildren = withoutEqualDigests(children, otherChildren)
+	}
+	childKey := func(child *MerkleNode) string {
+		if n.unordered {
+			// the children have no labels, see makeUnordered
+			return child.Path
+		}
+		return string(child.Label)
+	}
+	otherChildByKey := make(map[string]*MerkleNode, len(otherChildren))
+	for _, child := range otherChildren {
+		otherChildByKey[childKey(child)] = child
+	}
+	var result []string
+	for _, child := range children {
+		otherChild, ok := otherChildByKey[childKey(child)]
+		if !ok {
+			result = append(result, child.Path)
+			continue
+		}
+		delete(otherChildByKey, childKey(child))
+		result = append(result, child.Diff(otherChild)...)
+	}
+	for _, child := range otherChildren {
+		if _, ok := otherChildByKey[childKey(child)]; ok {
+			result = append(result, child.Path)
+		}
+	}
+	if len(result) == 0 {
+		return []string{n.Path}
+	}
+	return result
+}
+
+// Labels returns the labels of the nodes on the path from the root
+// (excluding it) to `n`, the expected labels of MerkleProof.Verify.
+func (n *MerkleNode) Labels() [][]byte {
+	var labels [][]byte
+	for node := n; node.parent != nil; node = node.parent {
+		labels = append(labels, node.Label)
+	}
+	slices.Reverse(labels)
+	return labels
+}
+
+// withoutEqualDigests returns the children of `a` and `b` excluding
+// the ones with equal digests in both (matched one to one).
+func withoutEqualDigests(a, b []*MerkleNode) ([]*MerkleNode, []*MerkleNode) {
+	unmatched := make(map[string][]*MerkleNode, len(b))
+	for _, child := range b {
+		unmatched[string(child.Hash)] = append(unmatched[string(child.Hash)], child)
+	}
+	matched := make(map[*MerkleNode]struct{}, len(b))
+	var restA []*MerkleNode
+	for _, child := range a {
+		candidates := unmatched[string(child.Hash)]
+		if len(candidates) == 0 {
+			restA = append(restA, child)
+			continue
+		}
+		matched[candidates[0]] = struct{}{}
+		unmatched[string(child.Hash)] = candidates[1:]
+	}
+	var restB []*MerkleNode
+	for _, child := range b {
+		if _, ok := matched[child]; !ok {
+			restB = append(restB, child)
+		}
+	}
+	return restA, restB
+}
+
+// Proof returns the inclusion proof of the subtree with path `path`
+// (see ProcContext.Path) in the tree with root `n`.
+func (n *MerkleNode) Proof(path string) (*MerkleProof, error) {
+	node := n.Find(path)
+	if node == nil {
+		return nil, fmt.Errorf("there is no value with path '%s'", path)
+	}
+	proof := &MerkleProof{}
+	for ; node != n; node = node.parent {
+		parent := node.parent
+		step := MerkleProofStep{
+			Header: parent.Header,
+			Labels: make([][]byte, len(parent.Children)),
+			Hashes: make([]Hash, len(parent.Children)),
+		}
+		for idx, child := range parent.Children {
+			if child == node {
+				step.Index = idx
+			}
+			step.Labels[idx] = child.Label
+			step.Hashes[idx] = child.Hash
+		}
+		proof.Steps = append(proof.Steps, step)
+	}
+	return proof, nil
+}
+
+// MerkleProof is a proof that a subtree is included into a Merkle tree
+// (see MerkleNode.Proof).
+type MerkleProof struct {
+	// Steps are the inner nodes from the parent of the subtree to the root.
+	Steps []MerkleProofStep
+}
+
+// MerkleProofStep is an inner node of a Merkle tree in a MerkleProof.
+type MerkleProofStep struct {
+	Header []byte
+	Labels [][]byte
+	Hashes []Hash
+
+	// Index is the index of the child, which is on the path to the subtree.
+	Index int
+}
+
+// Verify returns true if the subtree with digest `hash` is included
+// into the tree with root digest `root` at the position identified by
+// `labels`: the labels of the nodes from the root (excluding it) to the
+// subtree (see MerkleNode.Labels).
+//
+// The digest of a subtree could be calculated independently
+// as CalcMerkleHash(value).Hash (given the same options).
+func (p *MerkleProof) Verify(labels [][]byte, hash Hash, root Hash, opts ...Option) (bool, error) {
+	if len(labels) != len(p.Steps) {
+		return false, nil
+	}
+	b := newMerkleBuilder(opts)
+	for idx, step := range p.Steps {
+		if step.Index < 0 || step.Index >= len(step.Hashes) || len(step.Labels) != len(step.Hashes) {
+			return false, fmt.Errorf("invalid proof step")
+		}
+		if !bytes.Equal(step.Labels[step.Index], labels[len(labels)-1-idx]) {
+			return false, nil
+		}
+		hashes := append([]Hash{}, step.Hashes...)
+		hashes[step.Index] = hash
+		var err error
+		hash, err = b.innerHash(step.Header, step.Labels, hashes)
+		if err != nil {
+			return false, err
+		}
+	}
+	return bytes.Equal(hash, root), nil
+}
+
+// CalcMerkleHash returns the Merkle tree of `obj`: the digests of every
+// struct field, slice/array element, map entry, value behind a pointer or
+// inside an interface, so the differing subtrees of two objects could
+// be found (see MerkleNode.Diff), and a single value could be verified
+// against the root digest (see MerkleNode.Proof).
+//
+// Leaves (numbers, strings, byte slices, values with a custom hashing,
+// see ObjectHasher and RegisterHashFunc) are hashed using the stable
+// HashFormatV2 encoding of HashBuilder, which also takes the options.
+//
+// Values shared through pointers are included as many times as they are
+// referenced; cycles are not supported.
+func CalcMerkleHash(obj any, opts ...Option) (*MerkleNode, error) {
+	b := newMerkleBuilder(opts)
+	node, err := b.build(reflect.ValueOf(obj), newProcContext(), nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build the Merkle tree: %w", err)
+	}
+	return node, nil
+}
+
+type merkleBuilder struct {
+	HashBuilder *HashBuilder
+
+	pointersOnPath map[pointerKey]struct{}
+}
+
+func newMerkleBuilder(opts []Option) *merkleBuilder {
+	opts = append(opts[:len(opts):len(opts)], OptionHashFormat(HashFormatV2))
+	return &merkleBuilder{
+		HashBuilder:    NewHashBuilderStable(nil, opts...),
+		pointersOnPath: map[pointerKey]struct{}{},
+	}
+}
+
+const (
+	merkleLeafPrefix  = 0
+	merkleInnerPrefix = 1
+)
+
+func (b *merkleBuilder) leafHash(v reflect.Value) (Hash, error) {
+	hb := b.HashBuilder
+	if err := hb.checkHashValue(); err != nil {
+		return nil, err
+	}
+	hb.reset()
+	if err := hb.writeUint8(merkleLeafPrefix); err != nil {
+		return nil, err
+	}
+	if err := hb.writeReflectValue(v); err != nil {
+		return nil, err
+	}
+	return hb.result(), nil
+}
+
+func (b *merkleBuilder) innerHash(header []byte, labels [][]byte, hashes []Hash) (Hash, error) {
+	hb := b.HashBuilder
+	if err := hb.checkHashValue(); err != nil {
+		return nil, err
+	}
+	hb.reset()
+	if err := hb.writeUint8(merkleInnerPrefix); err != nil {
+		return nil, err
+	}
+	if err := hb.writeString(unsafetools.CastBytesToString(header)); err != nil {
+		return nil, err
+	}
+	if err := hb.writeLength(len(hashes)); err != nil {
+		return nil, err
+	}
+	for idx, hash := range hashes {
+		if err := hb.writeString(unsafetools.CastBytesToString(labels[idx])); err != nil {
+			return nil, err
+		}
+		if err := hb.writeString(unsafetools.CastBytesToString(hash)); err != nil {
+			return nil, err
+		}
+	}
+	return hb.result(), nil
+}
+
+// isMerkleLeaf returns true if `v` is hashed as a whole.
+func (b *merkleBuilder) isMerkleLeaf(v reflect.Value, unordered bool) bool {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Pointer, reflect.Interface:
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && !unordered {
+			// like strings (unless the order of the bytes does not matter)
+			return true
+		}
+	default:
+		return true
+	}
+	return b.HashBuilder.customHashFunc(v) != nil
+}
+
+// build returns the subtree of value `v`; if `unordered` is true, then
+// the digest of the subtree does not depend on the order of the elements
+// of slice or array `v` (see `hash:"unordered"`).
+func (b *merkleBuilder) build(
+	v reflect.Value,
+	ctx *ProcContext,
+	label []byte,
+	unordered bool,
+) (*MerkleNode, error) {
+	node := &MerkleNode{
+		Path:  ctx.Path(),
+		Label: label,
+	}
+	if !v.IsValid() {
+		// an untyped nil
+		hash, err := b.leafHash(v)
+		if err != nil {
+			return nil, newPathError(ctx, nil, err)
+		}
+		node.Hash = hash
+		return node, nil
+	}
+	if b.isMerkleLeaf(v, unordered) {
+		hash, err := b.leafHash(v)
+		if err != nil {
+			return nil, newPathError(ctx, v.Type(), err)
+		}
+		node.Hash = hash
+		return node, nil
+	}
+
+	t := v.Type()
+	header := typeDescriptor(t)
+	switch v.Kind() {
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := b.addChild(node, v.Index(i), ctx.Next(fmt.Sprintf("[%d]", i)), []byte(strconv.Itoa(i))); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Interface:
+		header += " nil=" + strconv.FormatBool(v.IsNil())
+		if !v.IsNil() {
+			if err := b.addChild(node, v.Elem(), ctx.Next("{}"), nil); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Map:
+		header += " nil=" + strconv.FormatBool(v.IsNil()) + " len=" + strconv.Itoa(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key()
+			keyHash, err := b.leafHash(key)
+			if err != nil {
+				return nil, newPathError(ctx.nextMapKey(key), key.Type(), err)
+			}
+			if err := b.addChild(node, iter.Value(), ctx.Next(mapValuePathPart(key)), keyHash); err != nil {
+				return nil, err
+			}
+		}
+		sort.Slice(node.Children, func(i, j int) bool {
+			return bytes.Compare(node.Children[i].Label, node.Children[j].Label) < 0
+		})
+	case reflect.Pointer:
+		header += " nil=" + strconv.FormatBool(v.IsNil())
+		if !v.IsNil() {
+			key := newPointerKey(v)
+			if isTrackablePointer(v) {
+				if _, ok := b.pointersOnPath[key]; ok {
+					return nil, newPathError(ctx, t, fmt.Errorf("a cycle of pointers"))
+				}
+				b.pointersOnPath[key] = struct{}{}
+				defer delete(b.pointersOnPath, key)
+			}
+			if err := b.addChild(node, v.Elem(), ctx.Next("*"), nil); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Slice:
+		header += " nil=" + strconv.FormatBool(v.IsNil()) + " len=" + strconv.Itoa(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := b.addChild(node, v.Index(i), ctx.Next(fmt.Sprintf("[%d]", i)), []byte(strconv.Itoa(i))); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fT := t.Field(i)
+			policy := getHashFieldPolicy(fT.Tag)
+			if policy.Err != nil {
+				return nil, newPathError(ctx.Next(fT.Name), fT.Type, policy.Err)
+			}
+			if policy.Skip {
+				continue
+			}
+			fV := v.Field(i)
+			if fT.PkgPath != "" {
+				if !b.HashBuilder.config.ProcessUnexported {
+					continue
+				}
+				if !v.CanAddr() {
+					vWithAddr := reflect.New(t).Elem()
+					vWithAddr.Set(v)
+					v = vWithAddr
+				}
+				fV = unsafetools.FieldByIndexInValue(v.Addr(), i).Elem()
+			}
+			label := fT.PkgPath + "." + fT.Name
+			if policy.Name != "" {
+				label = policy.Name
+			}
+			fieldCtx := ctx.Next(fT.Name)
+			if policy.Unordered {
+				switch fV.Kind() {
+				case reflect.Slice, reflect.Array:
+				default:
+					return nil, newPathError(fieldCtx, fT.Type, fmt.Errorf("`hash:\"unordered\"` is applicable only to slices and arrays"))
+				}
+				child, err := b.build(fV, fieldCtx, []byte(label), true)
+				if err != nil {
+					return nil, err
+				}
+				child.parent = node
+				node.Children = append(node.Children, child)
+				continue
+			}
+			if err := b.addChild(node, fV, fieldCtx, []byte(label)); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, newPathError(ctx, t, fmt.Errorf("unexpected kind: %v", v.Kind()))
+	}
+
+	node.Header = []byte(header)
+	if unordered {
+		if err := b.makeUnordered(node); err != nil {
+			return nil, newPathError(ctx, t, err)
+		}
+		return node, nil
+	}
+	if err := b.updateInnerHash(node); err != nil {
+		return nil, newPathError(ctx, t, err)
+	}
+	return node, nil
+}
+
+func (b *merkleBuilder) addChild(
+	node *MerkleNode,
+	v reflect.Value,
+	ctx *ProcContext,
+	label []byte,
+) error {
+	child, err := b.build(v, ctx, label, false)
+	if err != nil {
+		return err
+	}
+	child.parent = node
+	node.Children = append(node.Children, child)
+	return nil
+}
+
+// makeUnordered makes the digest of inner node `node` independent of
+// the order of the children (see `hash:"unordered"`): they are ordered
+// by their digests and have no labels.
+func (b *merkleBuilder) makeUnordered(node *MerkleNode) error {
+	for _, child := range node.Children {
+		child.Label = nil
+	}
+	sort.Slice(node.Children, func(i, j int) bool {
+		return bytes.Compare(node.Children[i].Hash, node.Children[j].Hash) < 0
+	})
+	node.Header = append(node.Header, " unordered"...)
+	node.unordered = true
+	return b.updateInnerHash(node)
+}
+
+func (b *merkleBuilder) updateInnerHash(node *MerkleNode) error {
+	labels := make([][]byte, len(node.Children))
+	hashes := make([]Hash, len(node.Children))
+	for idx, child := range node.Children {
+		labels[idx] = child.Label
+		hashes[idx] = child.Hash
+	}
+	hash, err := b.innerHash(node.Header, labels, hashes)
+	if err != nil {
+		return err
+	}
+	node.Hash = hash
+	return nil
+}
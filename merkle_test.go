@@ -0,0 +1,162 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type merkleConfig struct {
+	Name     string
+	Ports    []int
+	Labels   map[string]string
+	Backend  *merkleBackend
+	Tags     []string `hash:"unordered"`
+	Revision int      `hash:"-"`
+	Extra    any
+}
+
+type merkleBackend struct {
+	Address string
+	Weight  float64
+}
+
+func testMerkleConfig() *merkleConfig {
+	return &merkleConfig{
+		Name:    "service",
+		Ports:   []int{80, 443},
+		Labels:  map[string]string{"env": "prod", "team": "core"},
+		Backend: &merkleBackend{Address: "10.0.0.1", Weight: 0.5},
+		Tags:    []string{"a", "b"},
+		Extra:   int64(1),
+	}
+}
+
+func TestCalcMerkleHash(t *testing.T) {
+	cfg := testMerkleConfig()
+	tree := must(CalcMerkleHash(cfg))
+	require.Equal(t, tree.Hash, must(CalcMerkleHash(testMerkleConfig())).Hash)
+
+	require.NotNil(t, tree.Find(".*.Ports.[1]"))
+	require.NotNil(t, tree.Find(".*.Labels.[env]"))
+	require.NotNil(t, tree.Find(".*.Backend.*.Weight"))
+	require.NotNil(t, tree.Find(".*.Extra.{}"))
+	require.Nil(t, tree.Find(".*.Revision"))
+
+	t.Run("diff", func(t *testing.T) {
+		other := testMerkleConfig()
+		other.Revision = 2
+		other.Tags = []string{"b", "a"}
+		require.Empty(t, tree.Diff(must(CalcMerkleHash(other))))
+
+		other.Backend.Weight = 1
+		other.Labels["team"] = "infra"
+		other.Ports = append(other.Ports, 8080)
+		require.ElementsMatch(t,
+			[]string{".*.Backend.*.Weight", ".*.Labels.[team]", ".*.Ports"},
+			tree.Diff(must(CalcMerkleHash(other))),
+		)
+	})
+
+	t.Run("diff_unordered", func(t *testing.T) {
+		a, b := testMerkleConfig(), testMerkleConfig()
+		a.Tags = []string{"x", "y", "z"}
+		b.Tags = []string{"z", "y", "w"}
+		require.Equal(t,
+			[]string{".*.Tags.[0]", ".*.Tags.[2]"},
+			must(CalcMerkleHash(a)).Diff(must(CalcMerkleHash(b))),
+		)
+
+		b.Tags = []string{"x", "q", "z"}
+		require.Equal(t,
+			[]string{".*.Tags.[1]"},
+			must(CalcMerkleHash(a)).Diff(must(CalcMerkleHash(b))),
+		)
+
+		b.Tags = []string{"z", "x", "y", "y"}
+		require.Equal(t,
+			[]string{".*.Tags"},
+			must(CalcMerkleHash(a)).Diff(must(CalcMerkleHash(b))),
+		)
+	})
+
+	t.Run("unordered_bytes", func(t *testing.T) {
+		type blob struct {
+			Data  []byte  `hash:"unordered"`
+			Array [2]byte `hash:"unordered"`
+		}
+		v2Opts := []Option{OptionHashFormat(HashFormatV2)}
+		for _, tc := range []struct {
+			A, B  blob
+			Equal bool
+		}{
+			{blob{Data: []byte{1, 2}}, blob{Data: []byte{2, 1}}, true},
+			{blob{Array: [2]byte{1, 2}}, blob{Array: [2]byte{2, 1}}, true},
+			{blob{Data: []byte{1, 2}}, blob{Data: []byte{1, 3}}, false},
+			{blob{Data: []byte{1, 1}}, blob{Data: []byte{1}}, false},
+		} {
+			merkleA, merkleB := must(CalcMerkleHash(tc.A)), must(CalcMerkleHash(tc.B))
+			hashA, hashB := must(CalcCryptoHashWithOptions(v2Opts, tc.A)), must(CalcCryptoHashWithOptions(v2Opts, tc.B))
+			require.Equal(t, tc.Equal, hashA.Equals(hashB), "%v %v", tc.A, tc.B)
+			require.Equal(t, tc.Equal, merkleA.Hash.Equals(merkleB.Hash), "%v %v", tc.A, tc.B)
+		}
+		tree := must(CalcMerkleHash(blob{Data: []byte{1, 2, 3}}))
+		require.Equal(t, []string{".Data.[1]"}, tree.Diff(must(CalcMerkleHash(blob{Data: []byte{1, 5, 3}}))))
+	})
+
+	t.Run("proof", func(t *testing.T) {
+		for _, path := range []string{".*.Backend.*.Address", ".*.Labels.[env]", ".*.Ports", ".*.Extra.{}", ""} {
+			node := tree.Find(path)
+			proof := must(tree.Proof(path))
+			require.True(t, must(proof.Verify(node.Labels(), node.Hash, tree.Hash)), path)
+		}
+
+		// a value verified independently of the whole object
+		labels := [][]byte{nil, []byte(".Backend"), nil, []byte(".Address")}
+		require.Equal(t, labels, tree.Find(".*.Backend.*.Address").Labels())
+		proof := must(tree.Proof(".*.Backend.*.Address"))
+		require.True(t, must(proof.Verify(labels, must(CalcMerkleHash("10.0.0.1")).Hash, tree.Hash)))
+		require.False(t, must(proof.Verify(labels, must(CalcMerkleHash("10.0.0.2")).Hash, tree.Hash)))
+		proof = must(tree.Proof(".*.Backend"))
+		require.True(t, must(proof.Verify(labels[:2], must(CalcMerkleHash(cfg.Backend)).Hash, tree.Hash)))
+
+		// a proof of a value does not prove it at another position
+		proof = must(tree.Proof(".*.Ports.[0]"))
+		port := must(CalcMerkleHash(80)).Hash
+		require.True(t, must(proof.Verify(tree.Find(".*.Ports.[0]").Labels(), port, tree.Hash)))
+		require.False(t, must(proof.Verify(tree.Find(".*.Ports.[1]").Labels(), port, tree.Hash)))
+		require.False(t, must(proof.Verify(tree.Find(".*.Ports").Labels(), port, tree.Hash)))
+		proof.Steps[0].Index = 1
+		require.False(t, must(proof.Verify(tree.Find(".*.Ports.[1]").Labels(), port, tree.Hash)))
+
+		_, err := tree.Proof(".*.NoSuchField")
+		require.Error(t, err)
+	})
+
+	t.Run("leaves", func(t *testing.T) {
+		leaf := tree.Find(".*.Name")
+		require.True(t, leaf.IsLeaf())
+		require.Equal(t, ".Name", string(leaf.Label))
+		require.False(t, tree.Find(".*.Ports").IsLeaf())
+		require.True(t, must(CalcMerkleHash([]byte("raw"))).IsLeaf())
+		require.NotEqual(t, must(CalcMerkleHash(nil)).Hash, must(CalcMerkleHash((*int)(nil))).Hash)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		loop := &testType{}
+		loop.SomePointer = loop
+		_, err := CalcMerkleHash(loop)
+		require.Error(t, err)
+
+		_, err = CalcMerkleHash(struct{ F func() }{})
+		require.Error(t, err)
+
+		unknownAlg := OptionHashAlgorithm(endOfHashAlgorithm)
+		for _, v := range []any{1, nil, cfg} {
+			_, err = CalcMerkleHash(v, unknownAlg)
+			require.Error(t, err)
+		}
+		_, err = must(tree.Proof(".*.Name")).Verify(tree.Find(".*.Name").Labels(), tree.Find(".*.Name").Hash, tree.Hash, unknownAlg)
+		require.Error(t, err)
+	})
+}
@@ -5,8 +5,27 @@ type Option interface {
 }
 
 type config struct {
-	VisitorFunc       VisitorFunc
-	ProcessUnexported bool
+	VisitorFunc           VisitorFunc
+	ProcessUnexported     bool
+	IsUnexportedSet       bool
+	PreserveSliceAliasing bool
+	MapKeyCollisionPolicy MapKeyCollisionPolicy
+	HashFormat            HashFormat
+	IntOverflowPolicy     IntOverflowPolicy
+	HashTimeLocation      bool
+	HashAlgorithm         HashAlgorithm
+	FloatCanonicalization FloatCanonicalization
+	HashExplicitNil       bool
+	HashPointerSharing    bool
+}
+
+// isDefaultCopy returns true if the config does not change the behavior
+// of DeepCopy comparing to the default one.
+func (cfg config) isDefaultCopy() bool {
+	return cfg.VisitorFunc == nil &&
+		!cfg.ProcessUnexported &&
+		!cfg.PreserveSliceAliasing &&
+		cfg.MapKeyCollisionPolicy == MapKeyCollisionError
 }
 
 type Options []Option
@@ -23,14 +42,137 @@ func (s Options) config() config {
 	return cfg
 }
 
+// hashConfig returns the config of a HashBuilder, with the defaults
+// which depend on the format.
+func (s Options) hashConfig() config {
+	cfg := s.config()
+	if !cfg.IsUnexportedSet && cfg.HashFormat > HashFormatV1 {
+		cfg.ProcessUnexported = true
+	}
+	return cfg
+}
+
+// OptionWithUnexported makes the unexported fields processed as well.
+// By default they are skipped, except by HashBuilder in the formats
+// newer than HashFormatV1 (see HashFormatV2).
 type OptionWithUnexported bool
 
 func (opt OptionWithUnexported) apply(cfg *config) {
 	cfg.ProcessUnexported = bool(opt)
+	cfg.IsUnexportedSet = true
 }
 
+// OptionWithVisitorFunc adds a visitor function. If multiple visitor
+// functions are provided, then they are called in the given order,
+// see ChainVisitorFuncs.
 type OptionWithVisitorFunc VisitorFunc
 
 func (opt OptionWithVisitorFunc) apply(cfg *config) {
-	cfg.VisitorFunc = VisitorFunc(opt)
+	cfg.VisitorFunc = ChainVisitorFuncs(cfg.VisitorFunc, VisitorFunc(opt))
+}
+
+// OptionPreserveSliceAliasing makes DeepCopy reproduce the sharing
+// of backing arrays between slices: if two slices of the source object
+// overlap, then the copies overlap the same way. The capacities
+// (including the data in the spare capacity) are preserved as well.
+//
+// A visitor function sees the elements of a shared backing array only
+// once, with the indexes relative to the slice which was copied first.
+type OptionPreserveSliceAliasing bool
+
+func (opt OptionPreserveSliceAliasing) apply(cfg *config) {
+	cfg.PreserveSliceAliasing = bool(opt)
+}
+
+// OptionMapKeyCollision defines what to do if two different keys of a map
+// become equal after copying (for example, after their secrets are removed).
+//
+// The default is MapKeyCollisionError.
+type OptionMapKeyCollision MapKeyCollisionPolicy
+
+func (opt OptionMapKeyCollision) apply(cfg *config) {
+	cfg.MapKeyCollisionPolicy = MapKeyCollisionPolicy(opt)
+}
+
+// OptionHashFormat defines the format of the encoding of values
+// in HashBuilder. The default is HashFormatV1 (HashFormatV2 for
+// CalcCryptoHashV2, NewHashBuilderKeyed and CalcHash64).
+type OptionHashFormat HashFormat
+
+func (opt OptionHashFormat) apply(cfg *config) {
+	cfg.HashFormat = HashFormat(opt)
+}
+
+// OptionHashAlgorithm defines the hash function of HashBuilder-s created
+// with a nil hash, of CalcHashWithOptions and of CalcHash64. It is also
+// used to order the entries of maps. The default is HashAlgorithmSecure
+// (HashAlgorithmFNV64a for CalcHash64).
+type OptionHashAlgorithm HashAlgorithm
+
+func (opt OptionHashAlgorithm) apply(cfg *config) {
+	cfg.HashAlgorithm = HashAlgorithm(opt)
+}
+
+// IntOverflowPolicy defines what to do in the stable hashing if a value
+// of type int, uint or uintptr does not fit into 32 bits (so the same
+// value cannot exist on 32-bit platforms).
+type IntOverflowPolicy int
+
+const (
+	// IntOverflowIgnore just hashes the value (as any other value of
+	// these types, it is written as 64-bit).
+	IntOverflowIgnore = IntOverflowPolicy(iota)
+
+	// IntOverflowError makes the hashing fail with ErrIntOverflow.
+	IntOverflowError
+)
+
+// OptionIntOverflow defines the IntOverflowPolicy of the stable hashing.
+// The default is IntOverflowIgnore.
+type OptionIntOverflow IntOverflowPolicy
+
+func (opt OptionIntOverflow) apply(cfg *config) {
+	cfg.IntOverflowPolicy = IntOverflowPolicy(opt)
+}
+
+// OptionHashTimeLocation makes HashBuilder hash the location of
+// time.Time values in addition to the instant (by default the same
+// instant in different time zones has the same hash).
+type OptionHashTimeLocation bool
+
+func (opt OptionHashTimeLocation) apply(cfg *config) {
+	cfg.HashTimeLocation = bool(opt)
+}
+
+// OptionFloatCanonicalization defines how floating-point values are
+// normalized before being hashed by HashBuilder. By default they
+// are hashed as is (bit by bit).
+type OptionFloatCanonicalization FloatCanonicalization
+
+func (opt OptionFloatCanonicalization) apply(cfg *config) {
+	cfg.FloatCanonicalization = FloatCanonicalization(opt)
+}
+
+// OptionHashExplicitNil makes HashBuilder write nil-ness of slices, maps
+// and pointers, so for example a nil slice and an empty slice have
+// different hashes (HashFormatV2 writes nil-ness of pointers anyway).
+type OptionHashExplicitNil bool
+
+func (opt OptionHashExplicitNil) apply(cfg *config) {
+	cfg.HashExplicitNil = bool(opt)
+}
+
+// OptionHashPointerSharing makes HashBuilder write back-references to
+// the values behind pointers, which were already met during hashing of
+// the value, so the topology of the graph of pointers is taken into
+// account: for example, two fields pointing to the same value have a
+// different hash than two fields pointing to equal copies of the value.
+//
+// The entries of maps in HashFormatV2 and the elements of slices tagged
+// `hash:"unordered"` are hashed separately, so the back-references
+// are tracked within each of them independently.
+type OptionHashPointerSharing bool
+
+func (opt OptionHashPointerSharing) apply(cfg *config) {
+	cfg.HashPointerSharing = bool(opt)
 }
@@ -0,0 +1,284 @@
+package object
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// pointerKey identifies a value behind a pointer. The address alone is not
+// enough: for example, a struct and its first field have the same address
+// (and so may distinct values of zero size).
+type pointerKey struct {
+	Address uintptr
+	Type    reflect.Type
+}
+
+func newPointerKey(ptr reflect.Value) pointerKey {
+	return pointerKey{
+		Address: ptr.Pointer(),
+		Type:    ptr.Type().Elem(),
+	}
+}
+
+// isTrackablePointer returns false if the identity of the value behind
+// the pointer cannot be tracked. Values of zero size may share the same
+// address with unrelated values, but they also cannot contain anything
+// to recurse into, so it is safe to just never consider them as visited.
+func isTrackablePointer(ptr reflect.Value) bool {
+	return ptr.Type().Elem().Size() > 0
+}
+
+// copiedRegionsGranularity is the size of the address space chunks
+// which are used to index copiedRegion-s.
+const copiedRegionsGranularity = 512
+
+// copiedRegion is a memory region of the source object that was copied
+// (as the value behind a pointer). It is used to resolve pointers into
+// the internals of the already copied values (for example, to a field
+// of an already copied struct).
+type copiedRegion struct {
+	Start uintptr
+	End   uintptr
+	Type  reflect.Type
+	Copy  unsafe.Pointer
+}
+
+// registerCopiedPointer remembers that the value behind source pointer
+// `src` is copied to the value behind pointer `dst`.
+func (c *deepCopier) registerCopiedPointer(src, dst reflect.Value) {
+	if !isTrackablePointer(src) {
+		return
+	}
+	if c.copiedValuesBehindPointers == nil {
+		c.copiedValuesBehindPointers = make(map[pointerKey]reflect.Value)
+	}
+	key := newPointerKey(src)
+	c.copiedValuesBehindPointers[key] = dst
+	if c.copiedRegionsIndexed {
+		c.registerCopiedRegion(key.Address, key.Type, dst.UnsafePointer())
+		return
+	}
+	if n := len(c.copiedPointeeTypes); n > 0 && c.copiedPointeeTypes[n-1] == key.Type {
+		return
+	}
+	for _, t := range c.copiedPointeeTypes {
+		if t == key.Type {
+			return
+		}
+	}
+	if c.copiedPointeeTypes == nil {
+		c.copiedPointeeTypes = c.copiedPointeeTypesBuf[:0]
+	}
+	c.copiedPointeeTypes = append(c.copiedPointeeTypes, key.Type)
+}
+
+// registerCopiedRegion remembers that the source memory of value of
+// type `t` at address `start` is copied to `copy`.
+func (c *deepCopier) registerCopiedRegion(start uintptr, t reflect.Type, copy unsafe.Pointer) {
+	if c.copiedRegions == nil {
+		c.copiedRegions = make(map[uintptr][]copiedRegion)
+	}
+	region := copiedRegion{
+		Start: start,
+		End:   start + t.Size(),
+		Type:  t,
+		Copy:  copy,
+	}
+	for chunk := region.Start / copiedRegionsGranularity; chunk <= (region.End-1)/copiedRegionsGranularity; chunk++ {
+		c.copiedRegions[chunk] = append(c.copiedRegions[chunk], region)
+	}
+}
+
+// indexCopiedRegions makes copiedRegions include all the values copied
+// behind pointers (so far and from now on).
+//
+// The index is built lazily: in most objects there are no pointers into
+// the internals of other values, and then it is not worth the allocations.
+func (c *deepCopier) indexCopiedRegions() {
+	if c.copiedRegionsIndexed {
+		return
+	}
+	c.copiedRegionsIndexed = true
+	for key, dst := range c.copiedValuesBehindPointers {
+		c.registerCopiedRegion(key.Address, key.Type, dst.UnsafePointer())
+	}
+}
+
+// lookupCopiedRegion returns the address of the copy of the source
+// memory [start, start+size) if it is a part of an already copied region
+// (and was actually copied, see isCopiedMemory).
+func (c *deepCopier) lookupCopiedRegion(start, size uintptr) (unsafe.Pointer, bool) {
+	end := start + size
+	for _, region := range c.copiedRegions[start/copiedRegionsGranularity] {
+		if start < region.Start || end > region.End {
+			continue
+		}
+		if !isCopiedMemory(region.Type, start-region.Start, size, c.config.ProcessUnexported) {
+			continue
+		}
+		return unsafe.Add(region.Copy, start-region.Start), true
+	}
+	return nil, false
+}
+
+// lookupCopiedPointer returns the copy of the value behind source pointer
+// `src` (as a pointer of the same type as `src`) if it was already copied,
+// directly or as a part of another value.
+func (c *deepCopier) lookupCopiedPointer(src reflect.Value) (reflect.Value, bool) {
+	if !isTrackablePointer(src) {
+		return reflect.Value{}, false
+	}
+	key := newPointerKey(src)
+	result, ok := c.copiedValuesBehindPointers[key]
+	if !ok {
+		if !c.copiedRegionsIndexed {
+			if !c.mayBeInsideCopiedValue(key.Type) {
+				return reflect.Value{}, false
+			}
+			c.indexCopiedRegions()
+		}
+		copyPtr, ok := c.lookupCopiedRegion(key.Address, key.Type.Size())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		result = reflect.NewAt(key.Type, copyPtr)
+		if c.copiedValuesBehindPointers == nil {
+			c.copiedValuesBehindPointers = make(map[pointerKey]reflect.Value)
+		}
+		c.copiedValuesBehindPointers[key] = result
+	}
+	if t := src.Type(); result.Type() != t {
+		result = result.Convert(t)
+	}
+	return result, true
+}
+
+// mayBeInsideCopiedValue returns true if a value of type `t` could be
+// a part (a field or an array element) of any value copied behind
+// a pointer so far.
+func (c *deepCopier) mayBeInsideCopiedValue(t reflect.Type) bool {
+	for _, pointeeType := range c.copiedPointeeTypes {
+		if getInnerTypes(pointeeType, c.config.ProcessUnexported).contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// innerTypes is the set of types of the values which are copied as
+// parts (fields or array elements, recursively) of a value of some type.
+type innerTypes struct {
+	Types map[reflect.Type]struct{}
+
+	// ArrayElems are the element types of the inner arrays: a pointer to
+	// an array may also point to a part of another array.
+	ArrayElems map[reflect.Type]struct{}
+}
+
+var innerTypesCache sync.Map // copyPlanKey -> *innerTypes
+
+func getInnerTypes(t reflect.Type, processUnexported bool) *innerTypes {
+	key := copyPlanKey{Type: t, ProcessUnexported: processUnexported}
+	if inner, ok := innerTypesCache.Load(key); ok {
+		return inner.(*innerTypes)
+	}
+	inner := &innerTypes{
+		Types:      map[reflect.Type]struct{}{},
+		ArrayElems: map[reflect.Type]struct{}{},
+	}
+	inner.collect(t, processUnexported, false, map[innerTypesVisit]struct{}{})
+	innerTypesCache.Store(key, inner)
+	return inner
+}
+
+type innerTypesVisit struct {
+	Type reflect.Type
+	All  bool
+}
+
+// collect adds the inner types of type `t` to the set. If `all` is true,
+// then the value is copied as is (see tag `object:"shallow"`),
+// so all the fields are copied.
+func (inner *innerTypes) collect(
+	t reflect.Type,
+	processUnexported bool,
+	all bool,
+	visited map[innerTypesVisit]struct{},
+) {
+	visit := innerTypesVisit{Type: t, All: all}
+	if _, ok := visited[visit]; ok {
+		return
+	}
+	visited[visit] = struct{}{}
+	switch t.Kind() {
+	case reflect.Array:
+		inner.Types[t.Elem()] = struct{}{}
+		inner.ArrayElems[t.Elem()] = struct{}{}
+		inner.collect(t.Elem(), processUnexported, all, visited)
+	case reflect.Struct:
+		policies := getFieldPolicies(t)
+		for i := 0; i < t.NumField(); i++ {
+			fT := t.Field(i)
+			policy := &policies[i]
+			if !all && !policy.isCopied(fT.PkgPath == "", processUnexported) {
+				continue
+			}
+			inner.Types[fT.Type] = struct{}{}
+			inner.collect(fT.Type, processUnexported, all || policy.Shallow, visited)
+		}
+	}
+}
+
+func (inner *innerTypes) contains(t reflect.Type) bool {
+	if _, ok := inner.Types[t]; ok {
+		return true
+	}
+	if t.Kind() == reflect.Array {
+		_, ok := inner.ArrayElems[t.Elem()]
+		return ok
+	}
+	return false
+}
+
+// isCopiedMemory returns false if the memory [offset, offset+size) of
+// a value of type `t` includes fields which are not copied (for example,
+// fields tagged as `object:"-"`): their copies are left zero, so
+// the pointers to them should not be resolved into the copy.
+func isCopiedMemory(t reflect.Type, offset, size uintptr, processUnexported bool) bool {
+	end := offset + size
+	switch t.Kind() {
+	case reflect.Array:
+		elemSize := t.Elem().Size()
+		if elemSize == 0 {
+			return true
+		}
+		elemOffset := offset % elemSize
+		if elemOffset+size <= elemSize {
+			return isCopiedMemory(t.Elem(), elemOffset, size, processUnexported)
+		}
+		return isCopiedMemory(t.Elem(), 0, elemSize, processUnexported)
+	case reflect.Struct:
+		policies := getFieldPolicies(t)
+		for i := 0; i < t.NumField(); i++ {
+			fT := t.Field(i)
+			fEnd := fT.Offset + fT.Type.Size()
+			if fEnd <= offset || fT.Offset >= end {
+				continue
+			}
+			if !policies[i].isCopied(fT.PkgPath == "", processUnexported) {
+				return false
+			}
+			if policies[i].Shallow {
+				continue
+			}
+			fStart := max(offset, fT.Offset)
+			if !isCopiedMemory(fT.Type, fStart-fT.Offset, min(end, fEnd)-fStart, processUnexported) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
@@ -1,9 +1,11 @@
 package object
 
 import (
+	"crypto/hmac"
 	"crypto/sha512"
 	"fmt"
 	"hash"
+	"slices"
 
 	"lukechampine.com/blake3"
 )
@@ -13,6 +15,10 @@ const blake3Size = 64 // 512 bits
 type secureHash struct {
 	Blake3 *blake3.Hasher
 	SHA512 hash.Hash
+
+	// IsKeyed is true if the hash is keyed by Key (see newKeyedSecureHash).
+	IsKeyed bool
+	Key     []byte
 }
 
 var _ hash.Hash = (*secureHash)(nil)
@@ -24,6 +30,41 @@ func newSecureHash() *secureHash {
 	}
 }
 
+// keyedHashContext is the context of the derivation of BLAKE3 keys
+// from user-provided keys (which may be of any length). It must never
+// change (otherwise all the keyed hashes change); a new derivation
+// should get a new version.
+const keyedHashContext = "github.com/xaionaro-go/object keyed secure hash v1"
+
+// newKeyedSecureHash returns a secureHash, which is keyed BLAKE3
+// (with a key derived from `key`) and HMAC-SHA-512 (with `key`).
+func newKeyedSecureHash(key []byte) (*secureHash, error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	}
+	var blake3Key [32]byte
+	blake3.DeriveKey(blake3Key[:], keyedHashContext, key)
+	return &secureHash{
+		Blake3:  blake3.New(blake3Size, blake3Key[:]),
+		SHA512:  hmac.New(sha512.New, key),
+		IsKeyed: true,
+		Key:     slices.Clone(key),
+	}, nil
+}
+
+// newEmpty returns a new instance of the same (keyed or not) hash,
+// or nil if it cannot be created.
+func (h *secureHash) newEmpty() hash.Hash {
+	if !h.IsKeyed {
+		return newSecureHash()
+	}
+	newH, err := newKeyedSecureHash(h.Key)
+	if err != nil {
+		return nil
+	}
+	return newH
+}
+
 func (h *secureHash) Write(
 	p []byte,
 ) (n int, err error) {
@@ -3,25 +3,79 @@ package object
 import (
 	"fmt"
 	"reflect"
+
+	"github.com/xaionaro-go/unsafetools"
 )
 
 // Traverse recursively traverses the object `obj`.
+//
+// The visitor functions provided through options (see OptionWithVisitorFunc)
+// are called after `visitorFunc` (see ChainVisitorFuncs). By default
+// unexported fields are skipped (unless option `WithUnexported(true)`
+// is provided). If a visitor function modifies keys of a map, then
+// collisions are handled according to OptionMapKeyCollision.
 func Traverse(
 	obj any,
 	visitorFunc VisitorFunc,
+	opts ...Option,
 ) error {
-	_, err := newTraverser().traverse(reflect.ValueOf(obj), visitorFunc, newProcContext(), nil)
+	cfg := Options(opts).config()
+	visitorFunc = ChainVisitorFuncs(visitorFunc, cfg.VisitorFunc)
+	if visitorFunc == nil {
+		visitorFunc = func(_ *ProcContext, v reflect.Value, _ *reflect.StructField) (reflect.Value, bool, error) {
+			return v, true, nil
+		}
+	}
+	_, err := newTraverser(cfg).traverse(reflect.ValueOf(obj), visitorFunc, newProcContext(), nil)
 	return err
 }
 
 // VisitorFunc is called on every node during a traversal.
 type VisitorFunc func(*ProcContext, reflect.Value, *reflect.StructField) (reflect.Value, bool, error)
 
+// ChainVisitorFuncs returns a VisitorFunc which calls `funcs` in the given
+// order, each of them receives the value returned by the previous one.
+// The processing goes deeper only if all of them returned true.
+//
+// nil-s are ignored; if there is nothing to call, then nil is returned.
+func ChainVisitorFuncs(funcs ...VisitorFunc) VisitorFunc {
+	var chain []VisitorFunc
+	for _, f := range funcs {
+		if f != nil {
+			chain = append(chain, f)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+		goDeeper := true
+		for _, f := range chain {
+			newV, fGoDeeper, err := f(ctx, v, sf)
+			if err != nil {
+				return newV, false, err
+			}
+			v = newV
+			goDeeper = goDeeper && fGoDeeper
+			if !v.IsValid() {
+				// nothing to pass to the next one
+				break
+			}
+		}
+		return v, goDeeper, nil
+	}
+}
+
 // ProcContext is a structure provided to a callback on every call.
 type ProcContext struct {
-	parent *ProcContext
-	path   string
-	depth  uint
+	parent   *ProcContext
+	path     string
+	depth    uint
+	mapKey   bool
+	inMapKey bool
 
 	// CustomData is overwritable and all the children in the tree
 	// will receive this provided value.
@@ -40,6 +94,16 @@ func (ctx *ProcContext) Depth() uint {
 	return ctx.depth
 }
 
+// IsMapKey returns true if the node is a key of a map (not a value).
+func (ctx *ProcContext) IsMapKey() bool {
+	return ctx.mapKey
+}
+
+// InMapKey returns true if the node is a key of a map or a part of it.
+func (ctx *ProcContext) InMapKey() bool {
+	return ctx.inMapKey
+}
+
 func newProcContext() *ProcContext {
 	return &ProcContext{}
 }
@@ -49,16 +113,44 @@ func (ctx *ProcContext) Next(pathPart string) *ProcContext {
 		parent:     ctx,
 		path:       ctx.path + "." + pathPart,
 		depth:      ctx.depth + 1,
+		inMapKey:   ctx.inMapKey,
 		CustomData: ctx.CustomData,
 	}
 }
 
+// nextMapKey is the same as Next, but for a key of a map.
+func (ctx *ProcContext) nextMapKey(k reflect.Value) *ProcContext {
+	result := ctx.Next(mapKeyPathPart(k))
+	result.mapKey = true
+	result.inMapKey = true
+	return result
+}
+
+// mapKeyPathPart returns the path part (see ProcContext.Path) of key `k` of a map.
+func mapKeyPathPart(k reflect.Value) string {
+	return fmt.Sprintf("[%v](key)", k)
+}
+
+// mapValuePathPart returns the path part (see ProcContext.Path) of
+// the value of key `k` of a map.
+func mapValuePathPart(k reflect.Value) string {
+	return fmt.Sprintf("[%v]", k)
+}
+
 type traverser struct {
-	AlreadyVisitedPointers map[uintptr]struct{}
+	config                 config
+	AlreadyVisitedPointers map[pointerKey]struct{}
+
+	// PointersByAddress makes the pointers to be identified by
+	// the address only (ignoring the type), as it was before pointerKey
+	// was introduced. It is used by HashFormatV1.
+	PointersByAddress bool
 }
 
-func newTraverser() *traverser {
-	return &traverser{}
+func newTraverser(cfg config) *traverser {
+	return &traverser{
+		config: cfg,
+	}
 }
 
 func (traverser *traverser) traverse(
@@ -69,12 +161,15 @@ func (traverser *traverser) traverse(
 ) (_ret reflect.Value, _err error) {
 	newV, goInside, err := visitorFunc(ctx, v, structField)
 	if err != nil {
-		return newV, fmt.Errorf("received an error from the visitor function at '%s': %w", ctx.path, err)
+		return newV, newPathError(ctx, v.Type(), fmt.Errorf("received an error from the visitor function: %w", err))
 	}
 	if !goInside {
 		return newV, nil
 	}
 	v = newV
+	if !v.IsValid() {
+		return v, newPathError(ctx, nil, fmt.Errorf("received an invalid value from the visitor function"))
+	}
 
 	t := v.Type()
 
@@ -82,74 +177,119 @@ func (traverser *traverser) traverse(
 	case reflect.Array:
 		for i := 0; i < v.Len(); i++ {
 			idxV := v.Index(i)
-			newV, err := traverser.traverse(v.Index(i), visitorFunc, ctx.Next(fmt.Sprintf("[%d]", i)), nil)
-			if newV != idxV {
-				idxV.Set(newV)
-			}
+			idxCtx := ctx.Next(fmt.Sprintf("[%d]", i))
+			newV, err := traverser.traverse(idxV, visitorFunc, idxCtx, nil)
 			if err != nil {
 				return v, err
 			}
+			if newV != idxV {
+				if !idxV.CanSet() {
+					newArray := reflect.New(t).Elem()
+					newArray.Set(v)
+					v = newArray
+					idxV = v.Index(i)
+				}
+				if err := setValue(idxCtx, idxV, newV); err != nil {
+					return v, err
+				}
+			}
 		}
 	case reflect.Interface:
 		if !v.Elem().IsValid() {
 			return v, nil
 		}
-		newV, err := traverser.traverse(v.Elem(), visitorFunc, ctx.Next("{}"), nil)
-		if newV != v.Elem() {
-			v.Set(newV)
-		}
+		elemCtx := ctx.Next("{}")
+		newV, err := traverser.traverse(v.Elem(), visitorFunc, elemCtx, nil)
 		if err != nil {
 			return v, err
 		}
+		if newV != v.Elem() {
+			if !v.CanSet() {
+				v = reflect.New(t).Elem()
+			}
+			if err := setValue(elemCtx, v, newV); err != nil {
+				return v, err
+			}
+		}
 	case reflect.Map:
 		if v.IsNil() {
 			return v, nil
 		}
+		var movedKeys []movedMapKey
 		iter := v.MapRange()
 		for iter.Next() {
 			mapK := iter.Key()
 			mapV := iter.Value()
-			newV, err := traverser.traverse(mapV, visitorFunc, ctx.Next(fmt.Sprintf("[%v]", mapK)), nil)
-			if newV != mapV {
-				v.SetMapIndex(mapK, newV)
+			keyCtx := ctx.nextMapKey(mapK)
+			newK, err := traverser.traverse(mapK, visitorFunc, keyCtx, nil)
+			if err != nil {
+				return v, err
 			}
+			if newK != mapK {
+				if err := setValue(keyCtx, reflect.New(t.Key()).Elem(), newK); err != nil {
+					return v, err
+				}
+				movedKeys = append(movedKeys, movedMapKey{Ctx: keyCtx, Old: mapK, New: newK})
+			}
+			valueCtx := ctx.Next(mapValuePathPart(mapK))
+			newV, err := traverser.traverse(mapV, visitorFunc, valueCtx, nil)
 			if err != nil {
 				return v, err
 			}
+			if newV != mapV {
+				if !newV.IsValid() || !newV.Type().AssignableTo(t.Elem()) {
+					return v, setValue(valueCtx, reflect.New(t.Elem()).Elem(), newV)
+				}
+				v.SetMapIndex(mapK, newV)
+			}
+		}
+		if err := moveMapKeys(v, movedKeys, traverser.config.MapKeyCollisionPolicy); err != nil {
+			return v, err
 		}
 	case reflect.Pointer:
 		if v.IsNil() {
 			return v, nil
 		}
-		ptr := v.Pointer()
-		if traverser.AlreadyVisitedPointers == nil {
-			traverser.AlreadyVisitedPointers = make(map[uintptr]struct{})
-		}
-		if _, ok := traverser.AlreadyVisitedPointers[ptr]; ok {
-			return v, nil
+		if traverser.PointersByAddress || isTrackablePointer(v) {
+			ptr := newPointerKey(v)
+			if traverser.PointersByAddress {
+				ptr.Type = nil
+			}
+			if traverser.AlreadyVisitedPointers == nil {
+				traverser.AlreadyVisitedPointers = make(map[pointerKey]struct{})
+			}
+			if _, ok := traverser.AlreadyVisitedPointers[ptr]; ok {
+				return v, nil
+			}
+			traverser.AlreadyVisitedPointers[ptr] = struct{}{}
 		}
-		traverser.AlreadyVisitedPointers[ptr] = struct{}{}
 		vElem := v.Elem()
-		newV, err := traverser.traverse(vElem, visitorFunc, ctx.Next("*"), nil)
-		if newV != vElem {
-			vElem.Set(newV)
-		}
+		elemCtx := ctx.Next("*")
+		newV, err := traverser.traverse(vElem, visitorFunc, elemCtx, nil)
 		if err != nil {
 			return v, err
 		}
+		if newV != vElem {
+			if err := setValue(elemCtx, vElem, newV); err != nil {
+				return v, err
+			}
+		}
 	case reflect.Slice:
 		if v.IsNil() {
 			return v, nil
 		}
 		for i := 0; i < v.Len(); i++ {
 			idxV := v.Index(i)
-			newV, err := traverser.traverse(idxV, visitorFunc, ctx.Next(fmt.Sprintf("[%d]", i)), nil)
-			if newV != idxV {
-				idxV.Set(newV)
-			}
+			idxCtx := ctx.Next(fmt.Sprintf("[%d]", i))
+			newV, err := traverser.traverse(idxV, visitorFunc, idxCtx, nil)
 			if err != nil {
 				return v, err
 			}
+			if newV != idxV {
+				if err := setValue(idxCtx, idxV, newV); err != nil {
+					return v, err
+				}
+			}
 		}
 	case reflect.Struct:
 		for i := 0; i < v.NumField(); i++ {
@@ -157,11 +297,23 @@ func (traverser *traverser) traverse(
 			fT := t.Field(i)
 
 			if fT.PkgPath != "" {
-				// unexported
-				continue
+				if !traverser.config.ProcessUnexported {
+					// unexported
+					continue
+				}
+				if !v.CanAddr() {
+					vWithAddr := reflect.New(v.Type()).Elem()
+					vWithAddr.Set(v)
+					v = vWithAddr
+				}
+				fV = unsafetools.FieldByIndexInValue(v.Addr(), i).Elem()
 			}
 
-			newV, err := traverser.traverse(fV, visitorFunc, ctx.Next(fT.Name), &fT)
+			fieldCtx := ctx.Next(fT.Name)
+			newV, err := traverser.traverse(fV, visitorFunc, fieldCtx, &fT)
+			if err != nil {
+				return v, err
+			}
 			if newV != fV {
 				if !fV.CanSet() {
 					newStruct := reflect.New(v.Type()).Elem()
@@ -169,10 +321,9 @@ func (traverser *traverser) traverse(
 					v = newStruct
 					fV = v.Field(i)
 				}
-				fV.Set(newV)
-			}
-			if err != nil {
-				return v, err
+				if err := setValue(fieldCtx, fV, newV); err != nil {
+					return v, err
+				}
 			}
 		}
 	}
@@ -186,31 +337,51 @@ type Pointer[T any] interface {
 
 // RemoveSecrets returns zero-s all fields tagged as `secret:""`.
 //
-// Keep in mind, this function does not zero:
-// * the internals of: channels, function values, uintptr-s and unsafe.Pointer-s;
-// * the keys of maps.
+// Keep in mind, this function does not zero the internals of:
+// channels, function values, uintptr-s and unsafe.Pointer-s.
 //
-// Also, it does not copy unexported data!
-func RemoveSecrets[T any, PTR Pointer[T]](obj PTR) {
-	type markerIsSecretT struct{}
-	var markerIsSecret markerIsSecretT
-	err := Traverse(obj, func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
-		if sf == nil {
-			return v, true, nil
-		}
+// The keys of maps are censored as well. If two keys of a map become
+// equal, then the behavior is defined by OptionMapKeyCollision.
+//
+// Also, by default it does not process unexported data (unless
+// option `WithUnexported(true)` is provided)!
+//
+// The visitor functions (see OptionWithVisitorFunc) are called before
+// the secrets are removed.
+//
+// It panics on any error, see TryRemoveSecrets for the non-panicking version.
+func RemoveSecrets[T any, PTR Pointer[T]](
+	obj PTR,
+	opts ...Option,
+) {
+	if err := TryRemoveSecrets(obj, opts...); err != nil {
+		panic(err)
+	}
+}
 
-		_, isSecret := sf.Tag.Lookup("secret")
-		if !isSecret {
-			isSecret = ctx.CustomData == markerIsSecret
-		}
-		if !isSecret {
-			return v, true, nil
-		}
-		ctx.CustomData = markerIsSecret
+// TryRemoveSecrets is the same as RemoveSecrets, but returns an error
+// instead of panicking.
+//
+// The error (unless it is a bug in this package) is a *PathError.
+func TryRemoveSecrets[T any, PTR Pointer[T]](
+	obj PTR,
+	opts ...Option,
+) error {
+	return Traverse(obj, nil, append(opts[:len(opts):len(opts)], OptionWithVisitorFunc(removeSecretsVisitorFunc))...)
+}
 
-		return reflect.Zero(v.Type()), false, nil
-	})
-	if err != nil {
-		panic(err)
+// removeSecretsVisitorFunc is a VisitorFunc which zeroes all the fields
+// tagged as `secret:""`.
+func removeSecretsVisitorFunc(
+	_ *ProcContext,
+	v reflect.Value,
+	sf *reflect.StructField,
+) (reflect.Value, bool, error) {
+	if sf == nil {
+		return v, true, nil
+	}
+	if _, isSecret := sf.Tag.Lookup("secret"); !isSecret {
+		return v, true, nil
 	}
+	return reflect.Zero(v.Type()), false, nil
 }
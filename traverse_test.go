@@ -37,3 +37,141 @@ func TestRemoveSecrets(t *testing.T) {
 		require.Equal(t, *testSampleWithoutSecrets(), iface)
 	})
 }
+
+func TestTryRemoveSecrets(t *testing.T) {
+	sample := testSample()
+	require.NoError(t, TryRemoveSecrets(sample))
+	require.Equal(t, testSampleWithoutSecrets(), sample)
+}
+
+func TestTraverseInteriorPointers(t *testing.T) {
+	outer := &outerT{Inner: innerT{V: 1}}
+	sample := interiorPointersT{
+		Outer: outer,
+		Inner: &outer.Inner,
+	}
+
+	innerVisits := 0
+	err := Traverse(sample, func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+		if v.Type() == reflect.TypeOf(innerT{}) {
+			innerVisits++
+		}
+		return v, true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, innerVisits)
+}
+
+func TestRemoveSecretsMapKeys(t *testing.T) {
+	sample := map[mapKey]int{
+		{1, 2}: 1,
+		{2, 3}: 2,
+	}
+	RemoveSecrets(&sample)
+	require.Equal(t, map[mapKey]int{{1, 0}: 1, {2, 0}: 2}, sample)
+
+	sample = map[mapKey]int{
+		{1, 2}: 1,
+		{1, 3}: 2,
+	}
+	require.ErrorIs(t, TryRemoveSecrets(&sample), ErrMapKeyCollision)
+}
+
+func TestChainVisitorFuncs(t *testing.T) {
+	require.Nil(t, ChainVisitorFuncs(nil, nil))
+
+	var calls []string
+	visitor := func(name string, goDeeper bool) VisitorFunc {
+		return func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			calls = append(calls, name)
+			if v.Kind() == reflect.Int {
+				v = reflect.ValueOf(int(v.Int()) + 1)
+			}
+			return v, goDeeper, nil
+		}
+	}
+
+	chain := ChainVisitorFuncs(visitor("a", true), nil, visitor("b", false))
+	v, goDeeper, err := chain(newProcContext(), reflect.ValueOf(1), nil)
+	require.NoError(t, err)
+	require.False(t, goDeeper)
+	require.Equal(t, 3, v.Interface())
+	require.Equal(t, []string{"a", "b"}, calls)
+
+	t.Run("deep-copy", func(t *testing.T) {
+		result := DeepCopy(
+			[]int{1, 2},
+			OptionWithVisitorFunc(visitor("a", true)),
+			OptionWithVisitorFunc(visitor("b", true)),
+		)
+		require.Equal(t, []int{3, 4}, result)
+	})
+
+	t.Run("remove-secrets", func(t *testing.T) {
+		sample := testSample()
+		RemoveSecrets(sample, OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			if sf != nil && sf.Name == "SomePublicString" {
+				return reflect.ValueOf("censored"), false, nil
+			}
+			return v, true, nil
+		}))
+		require.Equal(t, "censored", sample.SomePublicString)
+		require.Equal(t, "censored", sample.SomePointer.SomePublicString)
+		require.Empty(t, sample.SomeSecretString)
+		require.Empty(t, sample.SomePointer.SomeSecretString)
+	})
+}
+
+type unexportedSecretsT struct {
+	Public  string
+	private struct {
+		Secret string `secret:""`
+		Public string
+	}
+}
+
+func TestRemoveSecretsUnexported(t *testing.T) {
+	sample := &unexportedSecretsT{Public: "public"}
+	sample.private.Secret = "secret"
+	sample.private.Public = "public"
+
+	RemoveSecrets(sample)
+	require.Equal(t, "secret", sample.private.Secret)
+
+	RemoveSecrets(sample, OptionWithUnexported(true))
+	require.Equal(t, "public", sample.Public)
+	require.Equal(t, "public", sample.private.Public)
+	require.Empty(t, sample.private.Secret)
+
+	t.Run("map-value", func(t *testing.T) {
+		sample := map[int]unexportedSecretsT{1: {}}
+		value := sample[1]
+		value.private.Secret = "secret"
+		sample[1] = value
+
+		RemoveSecrets(&sample, OptionWithUnexported(true))
+		require.Empty(t, sample[1].private.Secret)
+	})
+}
+
+func TestTraverseOptions(t *testing.T) {
+	var paths []string
+	err := Traverse(
+		&unexportedSecretsT{},
+		nil,
+		OptionWithUnexported(true),
+		OptionWithVisitorFunc(func(ctx *ProcContext, v reflect.Value, sf *reflect.StructField) (reflect.Value, bool, error) {
+			paths = append(paths, ctx.Path())
+			return v, true, nil
+		}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"", ".*", ".*.Public", ".*.private", ".*.private.Secret", ".*.private.Public"}, paths)
+
+	sample := map[mapKey]int{
+		{1, 2}: 1,
+		{1, 3}: 2,
+	}
+	RemoveSecrets(&sample, OptionMapKeyCollision(MapKeyCollisionDrop))
+	require.Empty(t, sample)
+}
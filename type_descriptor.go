@@ -0,0 +1,151 @@
+package object
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var typeDescriptors sync.Map // reflect.Type -> string
+
+// typeDescriptor returns a canonical description of type `t`, which
+// does not change between runs of the program (unlike the pointer to
+// the type), and which is different for types of different shapes.
+//
+// A defined type is described by its package path and name (including
+// the type arguments if it is generic); other types are described
+// structurally: kind, element/key types, array length, parameters and
+// results of functions, field names and tags of structs, methods of
+// interfaces. Struct fields tagged `hash:"-"` are omitted, and fields
+// tagged `hash:"name=..."` are described by that name.
+//
+// Package reflect does not provide the scope of a type, so the types
+// declared inside different functions of the same package with the same
+// name have the same descriptor.
+func typeDescriptor(t reflect.Type) string {
+	if descr, ok := typeDescriptors.Load(t); ok {
+		return descr.(string)
+	}
+	var buf strings.Builder
+	writeTypeDescriptor(&buf, t)
+	descr := buf.String()
+	typeDescriptors.Store(t, descr)
+	return descr
+}
+
+func writeTypeDescriptor(buf *strings.Builder, t reflect.Type) {
+	if t.Name() != "" {
+		buf.WriteString(t.PkgPath())
+		buf.WriteString(".")
+		buf.WriteString(t.Name())
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		buf.WriteString("[")
+		buf.WriteString(strconv.Itoa(t.Len()))
+		buf.WriteString("]")
+		writeTypeDescriptor(buf, t.Elem())
+	case reflect.Chan:
+		switch t.ChanDir() {
+		case reflect.RecvDir:
+			buf.WriteString("<-chan ")
+		case reflect.SendDir:
+			buf.WriteString("chan<- ")
+		default:
+			buf.WriteString("chan ")
+		}
+		writeTypeDescriptor(buf, t.Elem())
+	case reflect.Func:
+		buf.WriteString("func")
+		writeFuncTypeDescriptor(buf, t)
+	case reflect.Interface:
+		buf.WriteString("interface{")
+		for i := 0; i < t.NumMethod(); i++ {
+			m := t.Method(i)
+			if i > 0 {
+				buf.WriteString("; ")
+			}
+			if m.PkgPath != "" {
+				buf.WriteString(strconv.Quote(m.PkgPath))
+				buf.WriteString(".")
+			}
+			buf.WriteString(m.Name)
+			writeFuncTypeDescriptor(buf, m.Type)
+		}
+		buf.WriteString("}")
+	case reflect.Map:
+		buf.WriteString("map[")
+		writeTypeDescriptor(buf, t.Key())
+		buf.WriteString("]")
+		writeTypeDescriptor(buf, t.Elem())
+	case reflect.Pointer:
+		buf.WriteString("*")
+		writeTypeDescriptor(buf, t.Elem())
+	case reflect.Slice:
+		buf.WriteString("[]")
+		writeTypeDescriptor(buf, t.Elem())
+	case reflect.Struct:
+		buf.WriteString("struct{")
+		isFirst := true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			policy := getHashFieldPolicy(f.Tag)
+			if policy.Skip {
+				continue
+			}
+			if !isFirst {
+				buf.WriteString("; ")
+			}
+			isFirst = false
+			if f.Anonymous {
+				buf.WriteString("embedded ")
+			}
+			switch {
+			case policy.Name != "":
+				buf.WriteString(strconv.Quote(policy.Name))
+			case f.PkgPath != "":
+				buf.WriteString(strconv.Quote(f.PkgPath))
+				buf.WriteString(".")
+				buf.WriteString(f.Name)
+			default:
+				buf.WriteString(f.Name)
+			}
+			buf.WriteString(" ")
+			writeTypeDescriptor(buf, f.Type)
+			if f.Tag != "" {
+				buf.WriteString(" ")
+				buf.WriteString(strconv.Quote(string(f.Tag)))
+			}
+		}
+		buf.WriteString("}")
+	default:
+		// unnamed types of other kinds do not exist, but just in case:
+		buf.WriteString(t.Kind().String())
+	}
+}
+
+func writeFuncTypeDescriptor(buf *strings.Builder, t reflect.Type) {
+	buf.WriteString("(")
+	for i := 0; i < t.NumIn(); i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			buf.WriteString("...")
+			writeTypeDescriptor(buf, t.In(i).Elem())
+			continue
+		}
+		writeTypeDescriptor(buf, t.In(i))
+	}
+	buf.WriteString(") (")
+	for i := 0; i < t.NumOut(); i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		writeTypeDescriptor(buf, t.Out(i))
+	}
+	buf.WriteString(")")
+}